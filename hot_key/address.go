@@ -0,0 +1,195 @@
+package hotkeys
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// hostMatcher 监控的 redis 地址，支持 IPv4、IPv6 地址和 CIDR，多个用逗号分隔
+// 为空时匹配所有地址
+type hostMatcher struct {
+	prefixes []netip.Prefix
+}
+
+func newHostMatcher(hostIp string) (*hostMatcher, error) {
+	m := &hostMatcher{}
+	for _, item := range strings.Split(hostIp, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %s: %v", item, err)
+			}
+			m.prefixes = append(m.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip %s: %v", item, err)
+		}
+		addr = addr.Unmap()
+		m.prefixes = append(m.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return m, nil
+}
+
+func (m *hostMatcher) match(addr netip.Addr) bool {
+	if len(m.prefixes) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range m.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEndpoint 匹配网络层的地址
+func (m *hostMatcher) matchEndpoint(endpoint gopacket.Endpoint) bool {
+	addr, ok := netip.AddrFromSlice(endpoint.Raw())
+	if !ok {
+		return false
+	}
+	return m.match(addr)
+}
+
+// ipFamily 返回地址类型 ipv4 或 ipv6
+func ipFamily(endpoint gopacket.Endpoint) string {
+	addr, ok := netip.AddrFromSlice(endpoint.Raw())
+	if ok && addr.Unmap().Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// endpoint 一个监控的 redis 实例
+type endpoint struct {
+	index  int    // 实例序号，对应每个线程中的统计
+	name   string // ip:port
+	hostIp string // 地址，支持 IPv4、IPv6 和 CIDR，多个用逗号分隔，为空时匹配所有地址
+	hosts  *hostMatcher
+	port   layers.TCPPort
+}
+
+func newEndpoint(index int, hostIp string, port int) (*endpoint, error) {
+	hosts, err := newHostMatcher(hostIp)
+	if err != nil {
+		return nil, err
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	return &endpoint{
+		index:  index,
+		name:   net.JoinHostPort(hostIp, strconv.Itoa(port)),
+		hostIp: hostIp,
+		hosts:  hosts,
+		port:   layers.TCPPort(port),
+	}, nil
+}
+
+// parseEndpoints 解析 ip:port 列表，例如 10.0.0.1:6379、[2001:db8::1]:6380、:6381
+func parseEndpoints(list []string) ([]*endpoint, error) {
+	var eps []*endpoint
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %v", item, err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %v", item, err)
+		}
+		ep, err := newEndpoint(len(eps), host, p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %v", item, err)
+		}
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
+
+// matchEndpoints 查找地址和端口对应的实例，没有时返回 nil
+func matchEndpoints(eps []*endpoint, addr gopacket.Endpoint, port layers.TCPPort) *endpoint {
+	for _, ep := range eps {
+		if ep.port == port && ep.hosts.matchEndpoint(addr) {
+			return ep
+		}
+	}
+	return nil
+}
+
+// matchPacket 查找数据包所属的实例，toServer 为 true 时是请求方向
+func matchPacket(eps []*endpoint, flow gopacket.Flow, tcp *layers.TCP) (ep *endpoint, toServer bool) {
+	if ep = matchEndpoints(eps, flow.Dst(), tcp.DstPort); ep != nil {
+		return ep, true
+	}
+	return matchEndpoints(eps, flow.Src(), tcp.SrcPort), false
+}
+
+// hostFilter 按监控地址生成 BPF 过滤表达式，地址为空时返回空
+// libpcap 不接受带主机位的网段，CIDR 和 hostMatcher 一样按掩码后的网段过滤
+func hostFilter(hostIp string) string {
+	var hosts []string
+	for _, item := range strings.Split(hostIp, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			if prefix, err := netip.ParsePrefix(item); err == nil {
+				item = prefix.Masked().String()
+			}
+			hosts = append(hosts, "net "+item)
+		} else {
+			hosts = append(hosts, "host "+item)
+		}
+	}
+	if len(hosts) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(hosts, " or ") + ")"
+}
+
+// buildBpfFilter 按实例的端口和地址生成 BPF 过滤表达式
+func buildBpfFilter(eps []*endpoint) string {
+	if len(eps) == 1 {
+		filter := fmt.Sprintf("tcp port %d", eps[0].port)
+		if hosts := hostFilter(eps[0].hostIp); hosts != "" {
+			filter += " and " + hosts
+		}
+		return filter
+	}
+	var items []string
+	for _, ep := range eps {
+		item := fmt.Sprintf("port %d", ep.port)
+		if hosts := hostFilter(ep.hostIp); hosts != "" {
+			item = "(" + item + " and " + hosts + ")"
+		}
+		items = append(items, item)
+	}
+	return "tcp and (" + strings.Join(items, " or ") + ")"
+}
+
+// route 选择处理数据包的线程，FastHash 两个方向相同，同一连接的请求和响应分到同一个线程
+func route(netLayer gopacket.NetworkLayer, tcp *layers.TCP, eps []*endpoint, threadNum uint32) (int, bool) {
+	flow := netLayer.NetworkFlow()
+	if ep, _ := matchPacket(eps, flow, tcp); ep == nil {
+		return 0, false
+	}
+	return int(flow.FastHash() % uint64(threadNum)), true
+}
@@ -0,0 +1,158 @@
+package hotkeys
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHostMatcher(t *testing.T) {
+	hosts, err := newHostMatcher("10.0.0.1, 2001:db8::/64,::ffff:192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.0.0.1":        true,
+		"10.0.0.11":       false,
+		"2001:db8::1":     true,
+		"2001:db8:1::1":   false,
+		"192.168.1.1":     true,
+		"::ffff:10.0.0.1": true,
+	}
+	for ip, want := range cases {
+		if got := hosts.match(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("match %s got %v, want %v", ip, got, want)
+		}
+	}
+	if _, err = newHostMatcher("10.0.0.1/33"); err == nil {
+		t.Error("invalid cidr accepted")
+	}
+}
+
+func TestBuildBpfFilter(t *testing.T) {
+	cases := map[string]string{
+		"":                            "tcp port 6379",
+		"10.0.0.1":                    "tcp port 6379 and (host 10.0.0.1)",
+		"10.0.0.1, 2001:db8::/64":     "tcp port 6379 and (host 10.0.0.1 or net 2001:db8::/64)",
+		"10.0.0.5/24, 2001:db8::1/64": "tcp port 6379 and (net 10.0.0.0/24 or net 2001:db8::/64)",
+	}
+	for hostIp, want := range cases {
+		eps, err := hotKeyEndpoints(&HotKeyOptions{HostIp: hostIp, Port: 6379})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buildBpfFilter(eps); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	eps, err := parseEndpoints([]string{"10.0.0.1:6379", "[2001:db8::1]:6380", ":6381"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "tcp and ((port 6379 and (host 10.0.0.1)) or (port 6380 and (host 2001:db8::1)) or port 6381)"
+	if got := buildBpfFilter(eps); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, bad := range []string{"10.0.0.1", "2001:db8::1:6379", "10.0.0.1:0"} {
+		if _, err = parseEndpoints([]string{bad}); err == nil {
+			t.Errorf("invalid endpoint %s accepted", bad)
+		}
+	}
+}
+
+// readFixture 读取 testdata 下的 pcap 文件，按 ShowHotKeys 的方式分发到单个线程
+func readFixture(t *testing.T, name string, w *testWorker) {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := gopacket.NewPacketSource(reader, reader.LinkType())
+	var packets []*NetPacket
+	for packet := range source.Packets() {
+		netLayer := packet.NetworkLayer()
+		tcp, _ := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if netLayer != nil && tcp != nil {
+			if _, ok := route(netLayer, tcp, w.eps, 1); ok {
+				packets = append(packets, &NetPacket{PacketContent: packet})
+			}
+		}
+	}
+	w.feed(packets...)
+}
+
+func TestIPv6Fixture(t *testing.T) {
+	eps, err := hotKeyEndpoints(&HotKeyOptions{HostIp: "2001:db8::/64,10.0.0.1", Port: 6379})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newEndpointWorker(eps)
+	readFixture(t, "testdata/ipv6.pcap", w)
+	stat := w.stats[0]
+
+	if stat.TotalAccessSum != 4 {
+		t.Fatalf("got %d commands, want 4", stat.TotalAccessSum)
+	}
+	want := map[string]int64{"2001:db8::10": 3, "10.0.0.2": 1}
+	if len(stat.ClientCall) != len(want) {
+		t.Fatalf("got clients %d, want %d", len(stat.ClientCall), len(want))
+	}
+	for _, kv := range stat.ClientCall {
+		if want[kv.Key] != kv.Value {
+			t.Errorf("client %s got %d, want %d", kv.Key, kv.Value, want[kv.Key])
+		}
+	}
+	// ipv4_call 只包含 IPv4 客户端
+	if len(stat.IPV4Call) != 1 || stat.IPV4Call[0].Key != "10.0.0.2" || stat.IPV4Call[0].Value != 1 {
+		t.Fatalf("got ipv4 clients %+v", stat.IPV4Call)
+	}
+	family := map[string]int64{"ipv6": 3, "ipv4": 1}
+	for _, kv := range stat.ClientFamily {
+		if family[kv.Key] != kv.Value {
+			t.Errorf("family %s got %d, want %d", kv.Key, kv.Value, family[kv.Key])
+		}
+	}
+	if len(stat.SlowestCalls) != 4 {
+		t.Fatalf("got %d latencies, want 4", len(stat.SlowestCalls))
+	}
+}
+
+func TestMultipleEndpoints(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379", "10.0.0.1:6380")
+	start := time.Unix(1700000000, 0)
+	get := "*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"
+	a := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	b := newTestConn("10.0.0.2", "10.0.0.1", 40001, 6380)
+	// 其它端口的流量不统计
+	c := newTestConn("10.0.0.2", "10.0.0.1", 40002, 6381)
+	w.feed(
+		a.packet(t, true, get, start),
+		b.packet(t, true, get+get, start),
+		c.packet(t, true, get, start),
+		a.packet(t, false, "$1\r\nv\r\n", start.Add(time.Millisecond)),
+		b.packet(t, false, "$1\r\nv\r\n$1\r\nv\r\n", start.Add(time.Millisecond)),
+	)
+	if w.stats[0].TotalAccessSum != 1 || w.stats[1].TotalAccessSum != 2 {
+		t.Fatalf("got %d and %d commands, want 1 and 2", w.stats[0].TotalAccessSum, w.stats[1].TotalAccessSum)
+	}
+
+	// 合并为整体后每个实例的统计不变
+	combined := newOverallStats()
+	aggregation(combined, w.stats)
+	analysisCounter(combined, 10)
+	if combined.TotalAccessSum != 3 || combined.TopKeys[0].Value != 3 || combined.CommandLatency[0].Count != 3 {
+		t.Fatalf("got combined %d commands, top key %+v", combined.TotalAccessSum, combined.TopKeys[0])
+	}
+	analysisCounter(w.stats[1], 10)
+	if w.stats[1].TopKeys[0].Value != 2 || w.stats[1].TopCommands[0].Value != 2 || w.stats[1].CommandLatency[0].Count != 2 {
+		t.Fatalf("got instance top key %+v, command %+v", w.stats[1].TopKeys[0], w.stats[1].TopCommands[0])
+	}
+}
@@ -0,0 +1,140 @@
+package hotkeys
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var maxBlockingKeys = 10000 // 单线程最多记录的阻塞命令 key 数量，超过时替换调用最少的 key
+
+// BlockingStats 阻塞命令按 key 的等待统计，等待时间不计入服务端耗时
+type BlockingStats struct {
+	Key          string  `json:"key"`
+	Calls        int64   `json:"calls"`         // 匹配到响应的次数
+	Served       int64   `json:"served"`        // 等到数据返回的次数
+	Timeouts     int64   `json:"timeouts"`      // 超时返回的次数
+	TimeoutRatio float64 `json:"timeout_ratio"` // 超时比例
+	WaitTime     int64   `json:"wait_time"`     // 总等待时间，微秒
+	AvgWait      int64   `json:"avg_wait"`      // 平均等待时间，微秒
+	MaxWait      int64   `json:"max_wait"`      // 最大等待时间，微秒
+}
+
+// streamBlocking XREAD 和 XREADGROUP 在 STREAMS 之前带 BLOCK 参数时为阻塞命令
+func streamBlocking(args []string) bool {
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BLOCK":
+			return true
+		case "STREAMS":
+			return false
+		}
+	}
+	return false
+}
+
+func blockingStats(stat *OverallStats, key string) *BlockingStats {
+	s, ok := stat.tmpBlocking[key]
+	if !ok {
+		s = &BlockingStats{Key: key}
+		stat.tmpBlocking[key] = s
+	}
+	return s
+}
+
+// blockingServed 阻塞命令是否等到了数据，超时返回 nil；WAIT 和 WAITAOF 返回的数量不足时为超时
+func blockingServed(c *redisCommand, reply *respValue) bool {
+	switch c.cmd {
+	case "WAIT":
+		// WAIT numreplicas timeout 返回确认的副本数
+		return reply.kind == ':' && countReached(c.args[1], reply.str)
+	case "WAITAOF":
+		// WAITAOF numlocal numreplicas timeout 返回 [本地, 副本] 数组
+		return reply.kind == '*' && len(reply.elems) == 2 && len(c.args) > 2 &&
+			countReached(c.args[1], reply.elems[0].str) && countReached(c.args[2], reply.elems[1].str)
+	}
+	return !reply.isNil
+}
+
+// countReached 响应中的数量是否达到命令要求的数量
+func countReached(want, got string) bool {
+	w, err := strconv.ParseInt(want, 10, 64)
+	g, err2 := strconv.ParseInt(got, 10, 64)
+	return err == nil && err2 == nil && g >= w
+}
+
+// blockingReplyInfo 按响应统计阻塞命令是否超时，错误响应没有等待，按普通命令统计耗时
+func blockingReplyInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	if !c.blocking {
+		return
+	}
+	if reply.kind == '-' || reply.kind == '!' {
+		c.blocking = false
+		return
+	}
+	if evicted, full := stat.blockingHitters.admit(c.redisCmd, 1); full {
+		delete(stat.tmpBlocking, evicted)
+	}
+	s := blockingStats(stat, c.redisCmd)
+	s.Calls++
+	if len(c.args) > 1 && blockingServed(c, reply) {
+		s.Served++
+	} else {
+		s.Timeouts++
+	}
+}
+
+// blockingLatencyInfo 记录阻塞命令的等待时间
+func blockingLatencyInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	if s, ok := stat.tmpBlocking[c.redisCmd]; ok {
+		s.WaitTime += execTime
+		if execTime > s.MaxWait {
+			s.MaxWait = execTime
+		}
+	}
+	h, ok := stat.tmpBlockingLatency[c.cmd]
+	if !ok {
+		h = newLatencyHistogram()
+		stat.tmpBlockingLatency[c.cmd] = h
+	}
+	h.record(execTime)
+}
+
+func aggregationBlocking(stat *OverallStats, newStat *OverallStats) {
+	for key, value := range newStat.tmpBlocking {
+		s := blockingStats(stat, key)
+		s.Calls += value.Calls
+		s.Served += value.Served
+		s.Timeouts += value.Timeouts
+		s.WaitTime += value.WaitTime
+		if value.MaxWait > s.MaxWait {
+			s.MaxWait = value.MaxWait
+		}
+	}
+	for key, value := range newStat.tmpBlockingLatency {
+		h, ok := stat.tmpBlockingLatency[key]
+		if !ok {
+			h = newLatencyHistogram()
+			stat.tmpBlockingLatency[key] = h
+		}
+		h.merge(value)
+	}
+}
+
+func analysisBlocking(stat *OverallStats, topNum int) {
+	for _, s := range stat.tmpBlocking {
+		if s.Calls > 0 {
+			s.TimeoutRatio = Decimal(float64(s.Timeouts) / float64(s.Calls))
+			s.AvgWait = s.WaitTime / s.Calls
+		}
+		stat.BlockingKeys = append(stat.BlockingKeys, s)
+	}
+	sort.Slice(stat.BlockingKeys, func(i, j int) bool { return stat.BlockingKeys[i].WaitTime > stat.BlockingKeys[j].WaitTime })
+	if len(stat.BlockingKeys) > topNum {
+		stat.BlockingKeys = stat.BlockingKeys[:topNum]
+	}
+	for key, h := range stat.tmpBlockingLatency {
+		stat.BlockingLatency = append(stat.BlockingLatency, h.summary(key))
+	}
+	sort.Slice(stat.BlockingLatency, func(i, j int) bool { return stat.BlockingLatency[i].Count > stat.BlockingLatency[j].Count })
+}
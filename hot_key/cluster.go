@@ -0,0 +1,138 @@
+package hotkeys
+
+import (
+	"redis_performance_analysis/big_key/dump"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const clusterSlots = 16384 // 集群的 slot 数量
+
+// SlotStats 集群一个 slot 的访问统计
+type SlotStats struct {
+	Slot  int     `json:"slot"`
+	Calls int64   `json:"calls"` // 访问次数
+	Qps   float64 `json:"qps"`   // 平均每秒访问次数
+	Moved int64   `json:"moved"` // 返回 MOVED 的次数
+	Ask   int64   `json:"ask"`   // 返回 ASK 的次数
+}
+
+// RedirectRatio 客户端收到集群重定向的比例
+type RedirectRatio struct {
+	Key   string  `json:"key"`
+	Total int64   `json:"total"` // 客户端访问次数
+	Moved int64   `json:"moved"` // 收到 MOVED 的次数
+	Ask   int64   `json:"ask"`   // 收到 ASK 的次数
+	Ratio float64 `json:"ratio"` // 重定向比例
+}
+
+// slotInfo 按 key 计算 slot 并统计访问次数
+func slotInfo(c *redisCommand, stat *OverallStats) {
+	if c.key == "" {
+		return
+	}
+	slotStats(stat, dump.Slot(c.key)).Calls++
+}
+
+func slotStats(stat *OverallStats, slot int) *SlotStats {
+	s, ok := stat.tmpSlots[slot]
+	if !ok {
+		s = &SlotStats{Slot: slot}
+		stat.tmpSlots[slot] = s
+	}
+	return s
+}
+
+// redirectInfo 统计 MOVED/ASK 错误，例如 MOVED 3999 127.0.0.1:6381
+func redirectInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	prefix := reply.errPrefix()
+	if prefix != "MOVED" && prefix != "ASK" {
+		return
+	}
+	fields := strings.Fields(reply.str)
+	slot := -1
+	if len(fields) >= 2 {
+		if n, err := strconv.Atoi(fields[1]); err == nil && n < clusterSlots {
+			slot = n
+		}
+	}
+	if slot < 0 && c.key != "" {
+		slot = dump.Slot(c.key)
+	}
+	client, ok := stat.tmpClientRedirects[c.clientIp]
+	if !ok {
+		client = &RedirectRatio{Key: c.clientIp}
+		stat.tmpClientRedirects[c.clientIp] = client
+	}
+	if prefix == "MOVED" {
+		stat.TotalMovedSum++
+		client.Moved++
+		if slot >= 0 {
+			slotStats(stat, slot).Moved++
+		}
+	} else {
+		stat.TotalAskSum++
+		client.Ask++
+		if slot >= 0 {
+			slotStats(stat, slot).Ask++
+		}
+	}
+}
+
+func aggregationCluster(stat *OverallStats, newStat *OverallStats) {
+	stat.TotalMovedSum += newStat.TotalMovedSum
+	stat.TotalAskSum += newStat.TotalAskSum
+	for slot, value := range newStat.tmpSlots {
+		s := slotStats(stat, slot)
+		s.Calls += value.Calls
+		s.Moved += value.Moved
+		s.Ask += value.Ask
+	}
+	for key, value := range newStat.tmpClientRedirects {
+		if client, ok := stat.tmpClientRedirects[key]; ok {
+			client.Moved += value.Moved
+			client.Ask += value.Ask
+		} else {
+			stat.tmpClientRedirects[key] = &RedirectRatio{Key: key, Moved: value.Moved, Ask: value.Ask}
+		}
+	}
+}
+
+// analysisCluster 需要在 ClientCall 截断之前调用，客户端访问次数从 ClientCall 中获取
+func analysisCluster(stat *OverallStats, topNum int) {
+	seconds := float64(stat.MonitorEndTime-stat.MonitorStartTime) / 1000 / 1000
+	for _, s := range stat.tmpSlots {
+		if seconds > 0 {
+			s.Qps = Decimal(float64(s.Calls) / seconds)
+		}
+		stat.HotSlots = append(stat.HotSlots, s)
+	}
+	sort.Slice(stat.HotSlots, func(i, j int) bool {
+		if stat.HotSlots[i].Calls != stat.HotSlots[j].Calls {
+			return stat.HotSlots[i].Calls > stat.HotSlots[j].Calls
+		}
+		return stat.HotSlots[i].Moved+stat.HotSlots[i].Ask > stat.HotSlots[j].Moved+stat.HotSlots[j].Ask
+	})
+	if len(stat.HotSlots) > topNum {
+		stat.HotSlots = stat.HotSlots[:topNum]
+	}
+	for _, client := range stat.tmpClientRedirects {
+		for _, kv := range stat.ClientCall {
+			if kv.Key == client.Key {
+				client.Total = kv.Value
+				break
+			}
+		}
+		if client.Total > 0 {
+			client.Ratio = Decimal(float64(client.Moved+client.Ask) / float64(client.Total))
+		}
+		stat.ClientRedirects = append(stat.ClientRedirects, client)
+	}
+	sort.Slice(stat.ClientRedirects, func(i, j int) bool {
+		return stat.ClientRedirects[i].Moved+stat.ClientRedirects[i].Ask > stat.ClientRedirects[j].Moved+stat.ClientRedirects[j].Ask
+	})
+	if len(stat.ClientRedirects) > topNum {
+		stat.ClientRedirects = stat.ClientRedirects[:topNum]
+	}
+}
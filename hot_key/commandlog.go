@@ -0,0 +1,186 @@
+package hotkeys
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// commandLogExts 目录中读取的命令日志文件类型
+var commandLogExts = []string{".txt", ".jsonl"}
+
+// ShowCommandLog 分析 --write-file 写入的命令日志，每行一个 FullKey 的 JSON
+// 日志中没有响应，耗时、错误和响应大小相关的统计为空
+func ShowCommandLog(ctx context.Context, opts *HotKeyOptions) (map[string]interface{}, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Separators != "" {
+		defer setSeparators(opts.Separators)()
+	}
+	files, err := commandLogFiles(opts.CommandLogs)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no command log file")
+	}
+	var capacity int
+	if opts.KeyMemory > 0 {
+		capacity = hitterCapacity(opts.KeyMemory, 1, opts.CmdLen)
+	}
+	stat := newSessionStats(opts, capacity)
+	stat.LatencyUnavailable = true
+	for _, name := range files {
+		log.Infof("分析命令日志 %s", name)
+		if err = readCommandLog(ctx, name, opts, stat); err != nil {
+			return nil, err
+		}
+	}
+	return analysisSource(stat, opts.Top), nil
+}
+
+// analysisSource 分析文件数据源的统计，没有命令时监控时间为当前时间
+func analysisSource(stat *OverallStats, topNum int) map[string]interface{} {
+	if stat.MonitorStartTime == 0 {
+		stat.MonitorStartTime = time.Now().UnixMicro()
+		stat.MonitorEndTime = stat.MonitorStartTime
+	}
+	log.Infof("分析数据结束")
+	return analysisCounter(stat, topNum)
+}
+
+// setSeparators 修改 key 前缀分隔符，返回恢复默认值的函数
+func setSeparators(sep string) func() {
+	old := separators
+	separators = sep
+	return func() {
+		separators = old
+	}
+}
+
+// commandLogFiles 展开目录，目录中只读取 .txt 和 .jsonl 文件
+func commandLogFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !InSlice(commandLogExts, filepath.Ext(entry.Name())) {
+				continue
+			}
+			files = append(files, filepath.Join(p, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// readCommandLog 逐行读取命令日志，按 StartTime 和 EndTime 过滤
+func readCommandLog(ctx context.Context, name string, opts *HotKeyOptions, stat *OverallStats) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readLines(ctx, name, f, func(line []byte) bool {
+		return commandLogLine(line, opts, stat)
+	})
+}
+
+// readLines 逐行处理，fn 返回 false 的行计为格式错误
+func readLines(ctx context.Context, name string, r io.Reader, fn func(line []byte) bool) error {
+	reader := bufio.NewReaderSize(r, 1<<20)
+	var invalid int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && !fn(line) {
+			invalid++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		log.Warnf("%s skip %d invalid lines", name, invalid)
+	}
+	return nil
+}
+
+// commandLogLine 统计一行命令，格式错误时返回 false
+func commandLogLine(line []byte, opts *HotKeyOptions, stat *OverallStats) bool {
+	var k FullKey
+	if err := json.Unmarshal(line, &k); err != nil {
+		return len(strings.TrimSpace(string(line))) == 0
+	}
+	if opts.StartTime > 0 && k.ReceiveTime < opts.StartTime {
+		return true
+	}
+	if opts.EndTime > 0 && k.ReceiveTime >= opts.EndTime {
+		return true
+	}
+	// 旧文件只有按空格拼接的 FullCmd，包含空格的参数无法还原
+	args := k.Args
+	if len(args) == 0 {
+		args = strings.Fields(k.FullCmd)
+	}
+	if len(args) == 0 {
+		return false
+	}
+	c := newRedisCommand(args, opts.CmdLen, k.ReceiveTime)
+	if c.ignore {
+		return true
+	}
+	clientIp, family := commandLogClient(k.Src)
+	sourceCommand(c, clientIp, family, k.Src, k.Dst, stat)
+	return true
+}
+
+// sourceCommand 统计文件数据源中的一条命令，监控时间取命令时间的范围
+func sourceCommand(c *redisCommand, clientIp, family, src, dst string, stat *OverallStats) {
+	c.clientIp = clientIp
+	commandInfo(c, clientIp, family, src, dst, stat, nil)
+	if stat.MonitorStartTime == 0 || c.receiveTime < stat.MonitorStartTime {
+		stat.MonitorStartTime = c.receiveTime
+	}
+	if c.receiveTime > stat.MonitorEndTime {
+		stat.MonitorEndTime = c.receiveTime
+	}
+}
+
+// commandLogClient 从 ip:port 中取出客户端地址和地址类型
+func commandLogClient(src string) (string, string) {
+	host, _, err := net.SplitHostPort(src)
+	if err != nil {
+		host = src
+	}
+	addr, err := netip.ParseAddr(host)
+	if err == nil && !addr.Unmap().Is4() {
+		return host, "ipv6"
+	}
+	return host, "ipv4"
+}
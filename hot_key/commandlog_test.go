@@ -0,0 +1,151 @@
+package hotkeys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCommandLog(t *testing.T, name string, keys ...FullKey) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, k := range keys {
+		if err = enc.Encode(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC).UnixMicro()
+	sec := time.Second.Microseconds()
+	writeCommandLog(t, filepath.Join(dir, "1_6379.txt"),
+		FullKey{FullCmd: "GET user|1001|name", ReceiveTime: base, Src: "10.0.0.1:5000", Dst: "10.0.0.9:6379"},
+		FullKey{FullCmd: "GET user|1001|age", ReceiveTime: base + sec, Src: "10.0.0.1:5000", Dst: "10.0.0.9:6379"},
+		FullKey{FullCmd: "AUTH secret", ReceiveTime: base + sec, Src: "10.0.0.1:5000", Dst: "10.0.0.9:6379"},
+	)
+	writeCommandLog(t, filepath.Join(dir, "2_6379.jsonl"),
+		FullKey{FullCmd: "set user|1002|name tom", ReceiveTime: base + 2*sec, Src: "[2001:db8::1]:5001", Dst: "[2001:db8::9]:6379"},
+		FullKey{FullCmd: "GET order|1", ReceiveTime: base + 10*sec, Src: "10.0.0.2:5002", Dst: "10.0.0.9:6379"},
+	)
+	// 目录中的其它文件不读取
+	if err := os.WriteFile(filepath.Join(dir, "1_6379.pcap"), []byte("not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ShowCommandLog(nil, &HotKeyOptions{
+		CommandLogs: []string{dir},
+		CmdLen:      9,
+		Top:         10,
+		Separators:  "|",
+		EndTime:     base + 10*sec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := data["total_sum"].(int64); total != 3 {
+		t.Fatalf("got total %d, want 3", total)
+	}
+	if start, end := data["monitor_start_time"].(int64), data["monitor_end_time"].(int64); start != base || end != base+2*sec {
+		t.Fatalf("got monitor time %d-%d", start, end)
+	}
+	topKeys := data["top_keys"].([]*KV)
+	if len(topKeys) != 2 || topKeys[0].Key != "GET user|1001" || topKeys[0].Value != 2 {
+		t.Fatalf("got top keys %+v", topKeys)
+	}
+	topPrefixes := data["top_prefixes"].([]*KV)
+	if len(topPrefixes) == 0 || topPrefixes[0].Key != "user" || topPrefixes[0].Value != 3 {
+		t.Fatalf("got top prefixes %+v", topPrefixes)
+	}
+	if family := data["client_family"].([]*KV); len(family) != 2 {
+		t.Fatalf("got client family %+v", family)
+	}
+	if separators != ":;,_- " {
+		t.Fatalf("separators not restored: %q", separators)
+	}
+}
+
+func TestCommandLogArgs(t *testing.T) {
+	policy, err := newRedactPolicy(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cw := newCommandWriter(bufio.NewWriter(&buf), policy)
+	cw.write(newRedisCommand([]string{"SET", "user 1", "a b"}, 100, 1), "10.0.0.1:5000", "10.0.0.9:6379")
+	if err = cw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	opts := &HotKeyOptions{CmdLen: 100, Top: 10}
+	stat := newOverallStats()
+	// 新文件按 Args 还原参数，旧文件按 FullCmd 的空格拆分
+	for _, line := range [][]byte{bytes.TrimSpace(buf.Bytes()), []byte(`{"FullCmd":"GET user:2","ReceiveTime":2,"Src":"10.0.0.1:5000","Dst":"10.0.0.9:6379"}`)} {
+		if !commandLogLine(line, opts, stat) {
+			t.Fatalf("line %s rejected", line)
+		}
+	}
+	if stat.tmpTopKeys["SET user 1"] != 1 || stat.tmpTopKeys["GET user:2"] != 1 {
+		t.Fatalf("got keys %v", stat.tmpTopKeys)
+	}
+}
+
+func TestRedactPolicy(t *testing.T) {
+	values, err := newRedactPolicy(true, []string{`[0-9]{11}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := newRedactPolicy(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 规则中带逗号
+	phones, err := newRedactPolicy(false, []string{`[0-9]{11,13}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tom, ex := hashValue("tom"), hashValue("EX")
+	cases := []struct {
+		policy *redactPolicy
+		cmd    string
+		want   string
+	}{
+		{secrets, "SET user:1 tom", "SET user:1 tom"},
+		{secrets, "AUTH admin secret", "AUTH *** ***"},
+		{secrets, "HELLO 3 AUTH admin secret SETNAME app", "HELLO 3 AUTH admin *** SETNAME app"},
+		{secrets, "MIGRATE 10.0.0.2 6379 key 0 1000 AUTH2 admin secret", "MIGRATE 10.0.0.2 6379 key 0 1000 AUTH2 admin ***"},
+		{secrets, "CONFIG SET maxmemory 1gb requirepass secret", "CONFIG SET maxmemory 1gb requirepass ***"},
+		{secrets, "ACL SETUSER app on >secret ~app:* +get", "ACL SETUSER app on *** ~app:* +get"},
+		{values, "SET user:1 tom EX 10", "SET user:1 " + tom + " " + ex + " " + hashValue("10")},
+		{values, "MSET a:1 tom b:1 tom", "MSET a:1 " + tom + " b:1 " + tom},
+		{values, "EVAL body 1 lock:1 tom", "EVAL " + hashValue("body") + " 1 lock:1 " + tom},
+		{values, "XREAD COUNT 10 STREAMS s:1 s:2 0 0", "XREAD COUNT 10 STREAMS s:1 s:2 " + hashValue("0") + " " + hashValue("0")},
+		{values, "CONFIG GET maxmemory", "CONFIG GET maxmemory"},
+		{values, "GET phone:13800138000", "GET phone:***"},
+		{values, "AUTH secret", "AUTH ***"},
+		{phones, "GET phone:8613800138000", "GET phone:***"},
+		{phones, "GET order:1234", "GET order:1234"},
+	}
+	for _, c := range cases {
+		args := strings.Fields(c.cmd)
+		if got := strings.Join(c.policy.redact(args), " "); got != c.want {
+			t.Errorf("redact %q got %q, want %q", c.cmd, got, c.want)
+		}
+		if strings.Join(args, " ") != c.cmd {
+			t.Errorf("redact %q modified args", c.cmd)
+		}
+	}
+	if _, err = newRedactPolicy(false, []string{"("}); err == nil {
+		t.Fatal("invalid rule accepted")
+	}
+}
@@ -0,0 +1,487 @@
+package hotkeys
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var maxClassPrefixes = 10000 // 单线程最多记录命令类别的前缀数量，超过时替换访问最少的前缀
+
+var (
+	minClassCalls   int64 = 100 // 前缀访问次数达到后才判断是否读多或写多
+	readMostlyRatio       = 0.9 // 读命令比例达到后适合客户端缓存
+	writeHeavyRatio       = 0.5 // 写命令比例达到后为写多的前缀
+)
+
+// commandFlags 命令类别，和 COMMAND INFO 的 flags 类似，一个命令可以属于多个类别
+type commandFlags uint8
+
+const (
+	flagRead     commandFlags = 1 << iota // 读取数据
+	flagWrite                             // 修改数据，删除和设置过期时间也是写命令
+	flagDelete                            // 删除 key 或元素
+	flagExpire                            // 设置或清除过期时间
+	flagAdmin                             // 管理和运维命令
+	flagBlocking                          // 阻塞等待的命令，不作为类别统计
+	flagScript                            // 参数为 脚本或函数 numkeys key... arg... 的命令，不作为类别统计
+
+	classFlags = flagRead | flagWrite | flagDelete | flagExpire | flagAdmin
+)
+
+// commandClassNames 类别在报告中的名称，未知命令为 other
+var commandClassNames = []struct {
+	flag commandFlags
+	name string
+}{
+	{flagRead, "read"},
+	{flagWrite, "write"},
+	{flagDelete, "delete"},
+	{flagExpire, "expire"},
+	{flagAdmin, "admin"},
+}
+
+// keySpec 命令中 key 的位置，和 COMMAND INFO 的 first key、last key、step 相同，last 为负数时从末尾计算
+// numkeys 大于 0 时为 numkeys 参数的位置，之后的 numkeys 个参数为 key
+type keySpec struct {
+	first, last, step int
+	numkeys           int
+}
+
+var (
+	noKeys       = keySpec{}
+	firstKey     = keySpec{1, 1, 1, 0}
+	allKeys      = keySpec{1, -1, 1, 0}
+	pairKeys     = keySpec{1, -1, 2, 0} // MSET key value key value
+	blockKeys    = keySpec{1, -2, 1, 0} // 最后一个参数为超时时间
+	twoKeys      = keySpec{1, 2, 1, 0}  // 源 key 和目标 key
+	subKeys      = keySpec{2, 2, 1, 0}  // OBJECT ENCODING key、MEMORY USAGE key
+	migrateKeys  = keySpec{3, 3, 1, 0}  // MIGRATE host port key
+	bitopKeys    = keySpec{2, -1, 1, 0} // BITOP operation destkey key...
+	storeNumKeys = keySpec{1, 1, 1, 2}  // ZUNIONSTORE destination numkeys key...
+	numKeys      = keySpec{0, 0, 0, 1}  // ZUNION numkeys key...
+	numKeys2     = keySpec{0, 0, 0, 2}  // EVAL script numkeys key...
+)
+
+// commandSpec 命令的类别和 key 的位置，keys 为空时第一个参数为 key，管理命令没有 key
+type commandSpec struct {
+	flags commandFlags
+	keys  *keySpec
+}
+
+// commandTable 常用命令的类别和 key 的位置，SET 和 GETEX 的过期参数在 commandClass 中判断
+// 不在表中的命令按第一个参数为 key 统计
+var commandTable = map[string]commandSpec{
+	// string
+	"GET":         {flags: flagRead},
+	"MGET":        {flags: flagRead, keys: &allKeys},
+	"GETRANGE":    {flags: flagRead},
+	"STRLEN":      {flags: flagRead},
+	"SUBSTR":      {flags: flagRead},
+	"LCS":         {flags: flagRead, keys: &twoKeys},
+	"GETEX":       {flags: flagRead},
+	"GETDEL":      {flags: flagRead | flagWrite | flagDelete},
+	"GETSET":      {flags: flagRead | flagWrite},
+	"SET":         {flags: flagWrite},
+	"SETNX":       {flags: flagWrite},
+	"SETEX":       {flags: flagWrite | flagExpire},
+	"PSETEX":      {flags: flagWrite | flagExpire},
+	"MSET":        {flags: flagWrite, keys: &pairKeys},
+	"MSETNX":      {flags: flagWrite, keys: &pairKeys},
+	"SETRANGE":    {flags: flagWrite},
+	"APPEND":      {flags: flagWrite},
+	"INCR":        {flags: flagWrite},
+	"INCRBY":      {flags: flagWrite},
+	"INCRBYFLOAT": {flags: flagWrite},
+	"DECR":        {flags: flagWrite},
+	"DECRBY":      {flags: flagWrite},
+	"GETBIT":      {flags: flagRead},
+	"BITCOUNT":    {flags: flagRead},
+	"BITPOS":      {flags: flagRead},
+	"BITFIELD_RO": {flags: flagRead},
+	"SETBIT":      {flags: flagWrite},
+	"BITFIELD":    {flags: flagWrite},
+	"BITOP":       {flags: flagWrite, keys: &bitopKeys},
+	"PFCOUNT":     {flags: flagRead, keys: &allKeys},
+	"PFADD":       {flags: flagWrite},
+	"PFMERGE":     {flags: flagWrite, keys: &allKeys},
+	// hash
+	"HGET":         {flags: flagRead},
+	"HMGET":        {flags: flagRead},
+	"HGETALL":      {flags: flagRead},
+	"HKEYS":        {flags: flagRead},
+	"HVALS":        {flags: flagRead},
+	"HLEN":         {flags: flagRead},
+	"HSTRLEN":      {flags: flagRead},
+	"HEXISTS":      {flags: flagRead},
+	"HSCAN":        {flags: flagRead},
+	"HRANDFIELD":   {flags: flagRead},
+	"HTTL":         {flags: flagRead},
+	"HPTTL":        {flags: flagRead},
+	"HSET":         {flags: flagWrite},
+	"HSETNX":       {flags: flagWrite},
+	"HMSET":        {flags: flagWrite},
+	"HINCRBY":      {flags: flagWrite},
+	"HINCRBYFLOAT": {flags: flagWrite},
+	"HDEL":         {flags: flagWrite | flagDelete},
+	"HEXPIRE":      {flags: flagWrite | flagExpire},
+	"HPEXPIRE":     {flags: flagWrite | flagExpire},
+	"HEXPIREAT":    {flags: flagWrite | flagExpire},
+	"HPEXPIREAT":   {flags: flagWrite | flagExpire},
+	"HPERSIST":     {flags: flagWrite | flagExpire},
+	// list
+	"LINDEX":     {flags: flagRead},
+	"LLEN":       {flags: flagRead},
+	"LRANGE":     {flags: flagRead},
+	"LPOS":       {flags: flagRead},
+	"LPUSH":      {flags: flagWrite},
+	"RPUSH":      {flags: flagWrite},
+	"LPUSHX":     {flags: flagWrite},
+	"RPUSHX":     {flags: flagWrite},
+	"LINSERT":    {flags: flagWrite},
+	"LSET":       {flags: flagWrite},
+	"LTRIM":      {flags: flagWrite | flagDelete},
+	"LREM":       {flags: flagWrite | flagDelete},
+	"LPOP":       {flags: flagRead | flagWrite | flagDelete},
+	"RPOP":       {flags: flagRead | flagWrite | flagDelete},
+	"LMPOP":      {flags: flagRead | flagWrite | flagDelete, keys: &numKeys},
+	"RPOPLPUSH":  {flags: flagRead | flagWrite, keys: &twoKeys},
+	"LMOVE":      {flags: flagRead | flagWrite, keys: &twoKeys},
+	"BLPOP":      {flags: flagRead | flagWrite | flagDelete | flagBlocking, keys: &blockKeys},
+	"BRPOP":      {flags: flagRead | flagWrite | flagDelete | flagBlocking, keys: &blockKeys},
+	"BLMPOP":     {flags: flagRead | flagWrite | flagDelete | flagBlocking, keys: &numKeys2},
+	"BRPOPLPUSH": {flags: flagRead | flagWrite | flagBlocking, keys: &twoKeys},
+	"BLMOVE":     {flags: flagRead | flagWrite | flagBlocking, keys: &twoKeys},
+	// set
+	"SISMEMBER":   {flags: flagRead},
+	"SMISMEMBER":  {flags: flagRead},
+	"SMEMBERS":    {flags: flagRead},
+	"SCARD":       {flags: flagRead},
+	"SRANDMEMBER": {flags: flagRead},
+	"SSCAN":       {flags: flagRead},
+	"SINTER":      {flags: flagRead, keys: &allKeys},
+	"SINTERCARD":  {flags: flagRead, keys: &numKeys},
+	"SUNION":      {flags: flagRead, keys: &allKeys},
+	"SDIFF":       {flags: flagRead, keys: &allKeys},
+	"SADD":        {flags: flagWrite},
+	"SMOVE":       {flags: flagWrite, keys: &twoKeys},
+	"SINTERSTORE": {flags: flagWrite, keys: &allKeys},
+	"SUNIONSTORE": {flags: flagWrite, keys: &allKeys},
+	"SDIFFSTORE":  {flags: flagWrite, keys: &allKeys},
+	"SREM":        {flags: flagWrite | flagDelete},
+	"SPOP":        {flags: flagRead | flagWrite | flagDelete},
+	// sorted set
+	"ZSCORE":           {flags: flagRead},
+	"ZMSCORE":          {flags: flagRead},
+	"ZCARD":            {flags: flagRead},
+	"ZCOUNT":           {flags: flagRead},
+	"ZLEXCOUNT":        {flags: flagRead},
+	"ZRANK":            {flags: flagRead},
+	"ZREVRANK":         {flags: flagRead},
+	"ZRANGE":           {flags: flagRead},
+	"ZREVRANGE":        {flags: flagRead},
+	"ZRANGEBYSCORE":    {flags: flagRead},
+	"ZREVRANGEBYSCORE": {flags: flagRead},
+	"ZRANGEBYLEX":      {flags: flagRead},
+	"ZREVRANGEBYLEX":   {flags: flagRead},
+	"ZRANDMEMBER":      {flags: flagRead},
+	"ZSCAN":            {flags: flagRead},
+	"ZINTER":           {flags: flagRead, keys: &numKeys},
+	"ZINTERCARD":       {flags: flagRead, keys: &numKeys},
+	"ZUNION":           {flags: flagRead, keys: &numKeys},
+	"ZDIFF":            {flags: flagRead, keys: &numKeys},
+	"ZADD":             {flags: flagWrite},
+	"ZINCRBY":          {flags: flagWrite},
+	"ZRANGESTORE":      {flags: flagWrite, keys: &twoKeys},
+	"ZINTERSTORE":      {flags: flagWrite, keys: &storeNumKeys},
+	"ZUNIONSTORE":      {flags: flagWrite, keys: &storeNumKeys},
+	"ZDIFFSTORE":       {flags: flagWrite, keys: &storeNumKeys},
+	"ZREM":             {flags: flagWrite | flagDelete},
+	"ZREMRANGEBYRANK":  {flags: flagWrite | flagDelete},
+	"ZREMRANGEBYSCORE": {flags: flagWrite | flagDelete},
+	"ZREMRANGEBYLEX":   {flags: flagWrite | flagDelete},
+	"ZPOPMIN":          {flags: flagRead | flagWrite | flagDelete},
+	"ZPOPMAX":          {flags: flagRead | flagWrite | flagDelete},
+	"ZMPOP":            {flags: flagRead | flagWrite | flagDelete, keys: &numKeys},
+	"BZPOPMIN":         {flags: flagRead | flagWrite | flagDelete | flagBlocking, keys: &blockKeys},
+	"BZPOPMAX":         {flags: flagRead | flagWrite | flagDelete | flagBlocking, keys: &blockKeys},
+	"BZMPOP":           {flags: flagRead | flagWrite | flagDelete | flagBlocking, keys: &numKeys2},
+	// stream
+	"XLEN":       {flags: flagRead},
+	"XRANGE":     {flags: flagRead},
+	"XREVRANGE":  {flags: flagRead},
+	"XREAD":      {flags: flagRead},
+	"XPENDING":   {flags: flagRead},
+	"XINFO":      {flags: flagRead},
+	"XADD":       {flags: flagWrite},
+	"XREADGROUP": {flags: flagRead | flagWrite},
+	"XACK":       {flags: flagWrite},
+	"XCLAIM":     {flags: flagWrite},
+	"XAUTOCLAIM": {flags: flagWrite},
+	"XGROUP":     {flags: flagWrite},
+	"XSETID":     {flags: flagWrite},
+	"XDEL":       {flags: flagWrite | flagDelete},
+	"XTRIM":      {flags: flagWrite | flagDelete},
+	// geo
+	"GEOPOS":               {flags: flagRead},
+	"GEODIST":              {flags: flagRead},
+	"GEOHASH":              {flags: flagRead},
+	"GEOSEARCH":            {flags: flagRead},
+	"GEORADIUS_RO":         {flags: flagRead},
+	"GEORADIUSBYMEMBER_RO": {flags: flagRead},
+	"GEOADD":               {flags: flagWrite},
+	"GEOSEARCHSTORE":       {flags: flagWrite, keys: &twoKeys},
+	"GEORADIUS":            {flags: flagWrite},
+	"GEORADIUSBYMEMBER":    {flags: flagWrite},
+	// key
+	"EXISTS":      {flags: flagRead, keys: &allKeys},
+	"TYPE":        {flags: flagRead},
+	"TTL":         {flags: flagRead},
+	"PTTL":        {flags: flagRead},
+	"EXPIRETIME":  {flags: flagRead},
+	"PEXPIRETIME": {flags: flagRead},
+	"SORT_RO":     {flags: flagRead},
+	"TOUCH":       {flags: flagRead, keys: &allKeys},
+	"SORT":        {flags: flagWrite},
+	"COPY":        {flags: flagWrite, keys: &twoKeys},
+	"RENAME":      {flags: flagWrite, keys: &twoKeys},
+	"RENAMENX":    {flags: flagWrite, keys: &twoKeys},
+	"MOVE":        {flags: flagWrite},
+	"DEL":         {flags: flagWrite | flagDelete, keys: &allKeys},
+	"UNLINK":      {flags: flagWrite | flagDelete, keys: &allKeys},
+	"EXPIRE":      {flags: flagWrite | flagExpire},
+	"PEXPIRE":     {flags: flagWrite | flagExpire},
+	"EXPIREAT":    {flags: flagWrite | flagExpire},
+	"PEXPIREAT":   {flags: flagWrite | flagExpire},
+	"PERSIST":     {flags: flagWrite | flagExpire},
+	// admin
+	"OBJECT":       {flags: flagAdmin, keys: &subKeys},
+	"MEMORY":       {flags: flagAdmin, keys: &subKeys},
+	"DEBUG":        {flags: flagAdmin},
+	"DUMP":         {flags: flagAdmin | flagRead, keys: &firstKey},
+	"RESTORE":      {flags: flagAdmin | flagWrite, keys: &firstKey},
+	"MIGRATE":      {flags: flagAdmin | flagWrite | flagDelete, keys: &migrateKeys},
+	"CONFIG":       {flags: flagAdmin},
+	"INFO":         {flags: flagAdmin},
+	"CLIENT":       {flags: flagAdmin},
+	"CLUSTER":      {flags: flagAdmin},
+	"SLOWLOG":      {flags: flagAdmin},
+	"LATENCY":      {flags: flagAdmin},
+	"ACL":          {flags: flagAdmin},
+	"KEYS":         {flags: flagAdmin | flagRead, keys: &firstKey},
+	"SCAN":         {flags: flagAdmin | flagRead},
+	"DBSIZE":       {flags: flagAdmin},
+	"FLUSHDB":      {flags: flagAdmin | flagWrite | flagDelete},
+	"FLUSHALL":     {flags: flagAdmin | flagWrite | flagDelete},
+	"SAVE":         {flags: flagAdmin},
+	"BGSAVE":       {flags: flagAdmin},
+	"BGREWRITEAOF": {flags: flagAdmin},
+	"SHUTDOWN":     {flags: flagAdmin},
+	"REPLICAOF":    {flags: flagAdmin},
+	"SLAVEOF":      {flags: flagAdmin},
+	"FAILOVER":     {flags: flagAdmin},
+	"SCRIPT":       {flags: flagAdmin},
+	"FUNCTION":     {flags: flagAdmin},
+	"MONITOR":      {flags: flagAdmin},
+	"SWAPDB":       {flags: flagAdmin | flagWrite},
+	// blocking
+	"WAIT":    {flags: flagBlocking, keys: &noKeys},
+	"WAITAOF": {flags: flagBlocking, keys: &noKeys},
+	// scripting
+	"EVAL":       {flags: flagScript, keys: &numKeys2},
+	"EVAL_RO":    {flags: flagScript, keys: &numKeys2},
+	"EVALSHA":    {flags: flagScript, keys: &numKeys2},
+	"EVALSHA_RO": {flags: flagScript, keys: &numKeys2},
+	"FCALL":      {flags: flagScript, keys: &numKeys2},
+	"FCALL_RO":   {flags: flagScript, keys: &numKeys2},
+	// connection
+	"WATCH":     {keys: &allKeys},
+	"AUTH":      {keys: &noKeys},
+	"HELLO":     {keys: &noKeys},
+	"PING":      {keys: &noKeys},
+	"ECHO":      {keys: &noKeys},
+	"SELECT":    {keys: &noKeys},
+	"MULTI":     {keys: &noKeys},
+	"EXEC":      {keys: &noKeys},
+	"DISCARD":   {keys: &noKeys},
+	"UNWATCH":   {keys: &noKeys},
+	"QUIT":      {keys: &noKeys},
+	"RESET":     {keys: &noKeys},
+	"READONLY":  {keys: &noKeys},
+	"READWRITE": {keys: &noKeys},
+	"ASKING":    {keys: &noKeys},
+	"COMMAND":   {keys: &noKeys},
+	"TIME":      {keys: &noKeys},
+	"ROLE":      {keys: &noKeys},
+	"LASTSAVE":  {keys: &noKeys},
+}
+
+// commandKeyIndexes 按 commandTable 返回命令中 key 的位置，XREAD 和 XREADGROUP 的 key 为 STREAMS 之后的前一半参数
+func commandKeyIndexes(cmd string, args []string) []int {
+	var keys []int
+	if cmd == "XREAD" || cmd == "XREADGROUP" {
+		for i := 1; i < len(args); i++ {
+			if strings.ToUpper(args[i]) == "STREAMS" {
+				for j := i + 1; j <= i+(len(args)-i-1)/2; j++ {
+					keys = append(keys, j)
+				}
+				break
+			}
+		}
+		return keys
+	}
+	spec := &firstKey
+	if info, ok := commandTable[cmd]; ok {
+		if info.keys != nil {
+			spec = info.keys
+		} else if info.flags&flagAdmin != 0 {
+			return nil
+		}
+	}
+	if spec.first > 0 {
+		last := spec.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := spec.first; i <= last && i < len(args); i += spec.step {
+			keys = append(keys, i)
+		}
+	}
+	if spec.numkeys > 0 && spec.numkeys < len(args) {
+		n, err := strconv.Atoi(args[spec.numkeys])
+		if err != nil {
+			return keys
+		}
+		for i := spec.numkeys + 1; i <= spec.numkeys+n && i < len(args); i++ {
+			keys = append(keys, i)
+		}
+	}
+	return keys
+}
+
+// PrefixClassStats 一个前缀按命令类别的访问次数，一个命令可以计入多个类别
+type PrefixClassStats struct {
+	Key        string  `json:"key"`
+	Total      int64   `json:"total"`       // 访问次数
+	Read       int64   `json:"read"`        // 读命令次数
+	Write      int64   `json:"write"`       // 写命令次数，包括删除和设置过期时间
+	Delete     int64   `json:"delete"`      // 删除 key 或元素的次数
+	Expire     int64   `json:"expire"`      // 设置或清除过期时间的次数
+	Admin      int64   `json:"admin"`       // 管理命令次数
+	Other      int64   `json:"other"`       // 不在命令表中的命令次数，例如脚本
+	ReadRatio  float64 `json:"read_ratio"`  // 读命令比例
+	WriteRatio float64 `json:"write_ratio"` // 写命令比例
+}
+
+// commandClass 命令的类别，SET 和 GETEX 带过期参数时同时设置过期时间
+func commandClass(c *redisCommand) commandFlags {
+	flags := commandTable[c.cmd].flags & classFlags
+	if len(c.args) < 3 {
+		return flags
+	}
+	switch c.cmd {
+	case "SET", "GETEX":
+		for _, arg := range c.args[2:] {
+			switch strings.ToUpper(arg) {
+			case "EX", "PX", "EXAT", "PXAT", "PERSIST":
+				flags |= flagWrite | flagExpire
+			case "GET":
+				flags |= flagRead
+			}
+		}
+	}
+	return flags
+}
+
+func classStats(stat *OverallStats, key string) *PrefixClassStats {
+	s, ok := stat.tmpPrefixClasses[key]
+	if !ok {
+		s = &PrefixClassStats{Key: key}
+		stat.tmpPrefixClasses[key] = s
+	}
+	return s
+}
+
+// classInfo 按命令类别统计整体和每个前缀的访问
+func classInfo(c *redisCommand, prefixes []string, stat *OverallStats) {
+	flags := commandClass(c)
+	if flags == 0 {
+		stat.tmpClasses["other"]++
+	}
+	for _, class := range commandClassNames {
+		if flags&class.flag != 0 {
+			stat.tmpClasses[class.name]++
+		}
+	}
+	for _, prefix := range prefixes {
+		if len(prefix) == 0 {
+			continue
+		}
+		if evicted, full := stat.classHitters.admit(prefix, 1); full {
+			delete(stat.tmpPrefixClasses, evicted)
+		}
+		s := classStats(stat, prefix)
+		s.Total++
+		if flags == 0 {
+			s.Other++
+		}
+		if flags&flagRead != 0 {
+			s.Read++
+		}
+		if flags&flagWrite != 0 {
+			s.Write++
+		}
+		if flags&flagDelete != 0 {
+			s.Delete++
+		}
+		if flags&flagExpire != 0 {
+			s.Expire++
+		}
+		if flags&flagAdmin != 0 {
+			s.Admin++
+		}
+	}
+}
+
+func aggregationClass(stat *OverallStats, newStat *OverallStats) {
+	for key, value := range newStat.tmpClasses {
+		stat.tmpClasses[key] += value
+	}
+	for key, value := range newStat.tmpPrefixClasses {
+		s := classStats(stat, key)
+		s.Total += value.Total
+		s.Read += value.Read
+		s.Write += value.Write
+		s.Delete += value.Delete
+		s.Expire += value.Expire
+		s.Admin += value.Admin
+		s.Other += value.Other
+	}
+}
+
+// analysisClass 按访问次数排序，访问次数足够的前缀按读写比例找出适合客户端缓存和写多的前缀
+func analysisClass(stat *OverallStats, topNum int) {
+	stat.CommandClasses = topKv(stat.tmpClasses, topNum)
+	all := make([]*PrefixClassStats, 0, len(stat.tmpPrefixClasses))
+	for _, s := range stat.tmpPrefixClasses {
+		s.ReadRatio = Decimal(float64(s.Read) / float64(s.Total))
+		s.WriteRatio = Decimal(float64(s.Write) / float64(s.Total))
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Total > all[j].Total })
+	for _, s := range all {
+		if s.Total < minClassCalls {
+			break
+		}
+		if s.ReadRatio >= readMostlyRatio && len(stat.ReadMostlyPrefixes) < topNum {
+			stat.ReadMostlyPrefixes = append(stat.ReadMostlyPrefixes, s)
+		}
+		if s.WriteRatio >= writeHeavyRatio && len(stat.WriteHeavyPrefixes) < topNum {
+			stat.WriteHeavyPrefixes = append(stat.WriteHeavyPrefixes, s)
+		}
+	}
+	if len(all) > topNum {
+		all = all[:topNum]
+	}
+	stat.PrefixClasses = all
+}
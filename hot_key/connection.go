@@ -0,0 +1,257 @@
+package hotkeys
+
+import (
+	"github.com/google/gopacket/layers"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	shortConnTime   = time.Second      // 存活时间小于该值的连接为短连接
+	idleConnTime    = time.Minute      // 最长空闲时间超过该值的连接为长时间空闲连接
+	connIdleTimeout = 10 * time.Minute // 连接表中空闲超过该时间的连接被淘汰
+	connCloseGrace  = 30 * time.Second // 已关闭的连接在连接表中保留的时间，迟到的 FIN、ACK 和 RST 不会重建连接
+	maxConnReport   = 1000             // 每个线程保留的短连接和空闲连接条数
+)
+
+// ConnInfo 一个连接的生命周期
+type ConnInfo struct {
+	Key        string `json:"key"`         // 客户端 ip:port -> redis ip:port
+	OpenTime   int64  `json:"open_time"`   // 建立时间，没有抓到 SYN 时为第一个包的时间，时间戳，微秒
+	CloseTime  int64  `json:"close_time"`  // 关闭时间，未关闭时为 0，时间戳，微秒
+	LastActive int64  `json:"last_active"` // 最后一个包的时间，时间戳，微秒
+	Duration   int64  `json:"duration"`    // 存活时间，微秒
+	MaxIdle    int64  `json:"max_idle"`    // 最长空闲时间，微秒
+	Commands   int64  `json:"commands"`    // 命令数
+	Bytes      int64  `json:"bytes"`       // 双向负载字节数
+}
+
+// ChurnStats 一个客户端 IP 的连接建立和关闭次数
+type ChurnStats struct {
+	Key        string  `json:"key"`
+	Opened     int64   `json:"opened"`      // 新建连接数
+	Closed     int64   `json:"closed"`      // 关闭连接数
+	ShortLived int64   `json:"short_lived"` // 短连接数
+	Rate       float64 `json:"rate"`        // 平均每秒新建连接数
+}
+
+// connSession 连接的会话状态，空闲的重组流被释放后重建时仍然有效
+type connSession struct {
+	db   string // SELECT 选择的 db，中途抓到的连接未知
+	user string // AUTH 或 HELLO 认证成功的用户
+	name string // CLIENT SETNAME 或 HELLO SETNAME 设置的客户端名称
+	// 订阅状态同样保存在连接表中，重建的重组流不会重复统计订阅数
+	subscribed bool            // 连接订阅过频道或模式，之后会收到推送消息
+	channels   map[string]bool // 连接订阅过的频道和模式
+}
+
+// connState 连接表中的一个连接，按四元组保存在每个线程的统计中
+type connState struct {
+	ConnInfo
+	clientIp string
+	opened   bool   // 抓到了 SYN，建立时间准确
+	closed   bool   // 抓到了 FIN 或 RST
+	synSeq   uint32 // 客户端 SYN 的序号，用于区分重传的 SYN 和端口复用
+	session  connSession
+	// 网络质量统计，0 为客户端到 redis 的方向
+	seq        [2]tcpSeqState
+	synTime    int64   // 客户端 SYN 的时间
+	synAckTime int64   // redis SYN-ACK 的时间
+	handshaked bool    // 已统计握手耗时
+	zeroWindow [2]bool // 当前通告的窗口为零
+}
+
+// hostPort ip:port，端口不带 layers.TCPPort 的服务名
+func hostPort(ip string, port layers.TCPPort) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// connKey 连接表的键，客户端在前
+func connKey(src, dst string) string {
+	return src + " -> " + dst
+}
+
+// connectionInfo 按数据包更新连接表，src 和 dst 为客户端和 redis 的 ip:port
+func connectionInfo(stat *OverallStats, tcp *layers.TCP, clientIp, src, dst string, toServer bool, receiveTime int64) {
+	key := connKey(src, dst)
+	c, ok := stat.conns[key]
+	if ok && tcp.SYN && !tcp.ACK && toServer && !(c.opened && !c.closed && tcp.Seq == c.synSeq) {
+		// 端口复用，四元组上新的 SYN 结束旧连接，没有抓到关闭时以最后一个包的时间为关闭时间
+		if !c.closed {
+			closeConnection(stat, c, c.LastActive)
+		}
+		finishConnection(stat, c, receiveTime)
+		delete(stat.conns, key)
+		ok = false
+	}
+	if !ok {
+		c = &connState{
+			ConnInfo: ConnInfo{Key: key, OpenTime: receiveTime, LastActive: receiveTime},
+			clientIp: clientIp,
+		}
+		stat.conns[key] = c
+		// 空闲淘汰后恢复活跃的连接沿用之前的会话，新的 SYN 为新连接
+		if session, idle := stat.idleSessions[key]; idle && !tcp.SYN {
+			c.session = session
+		} else {
+			stat.ActiveProcessed++
+		}
+		delete(stat.idleSessions, key)
+	}
+	if idle := receiveTime - c.LastActive; idle > c.MaxIdle {
+		c.MaxIdle = idle
+	}
+	if receiveTime > c.LastActive {
+		c.LastActive = receiveTime
+	}
+	c.Bytes += int64(len(tcp.Payload))
+	if tcp.SYN && !tcp.ACK && toServer && !c.opened {
+		c.opened = true
+		c.OpenTime = receiveTime
+		c.synSeq = tcp.Seq
+		// 新建的连接没有 SELECT 时为默认的 db 0
+		c.session.db = "0"
+		stat.NewConnectNum++
+		churnStats(stat, c.clientIp).Opened++
+	}
+	if (tcp.FIN || tcp.RST) && !c.closed {
+		closeConnection(stat, c, receiveTime)
+	}
+	networkInfo(stat, c, tcp, dst, toServer, receiveTime)
+}
+
+// closeConnection 记录连接关闭
+func closeConnection(stat *OverallStats, c *connState, closeTime int64) {
+	c.closed = true
+	c.CloseTime = closeTime
+	stat.CloseConnectNum++
+	churnStats(stat, c.clientIp).Closed++
+}
+
+// connectionCommand 连接上解析到一条命令
+func connectionCommand(stat *OverallStats, src, dst string) {
+	if c, ok := stat.conns[connKey(src, dst)]; ok {
+		c.Commands++
+	}
+}
+
+func churnStats(stat *OverallStats, clientIp string) *ChurnStats {
+	s, ok := stat.tmpChurn[clientIp]
+	if !ok {
+		s = &ChurnStats{Key: clientIp}
+		stat.tmpChurn[clientIp] = s
+	}
+	return s
+}
+
+// evictConnections 按包时间淘汰关闭超过保留时间和空闲超时的连接，空闲的连接没有关闭，保留它的会话
+func evictConnections(stat *OverallStats, now int64) {
+	for key, c := range stat.conns {
+		if c.closed && now-c.CloseTime >= connCloseGrace.Microseconds() {
+			finishConnection(stat, c, now)
+			delete(stat.conns, key)
+		} else if !c.closed && now-c.LastActive >= connIdleTimeout.Microseconds() {
+			finishConnection(stat, c, now)
+			delete(stat.conns, key)
+			stat.idleSessions[key] = c.session
+		}
+	}
+}
+
+// finishConnections 监控结束时结束连接表中的全部连接
+func finishConnections(stat *OverallStats, now int64) {
+	for key, c := range stat.conns {
+		finishConnection(stat, c, now)
+		delete(stat.conns, key)
+	}
+}
+
+// finishConnection 连接从连接表中移除，记录短连接和长时间空闲连接
+func finishConnection(stat *OverallStats, c *connState, now int64) {
+	end := c.CloseTime
+	if !c.closed {
+		// 未关闭的连接，最后一个包之后的时间也是空闲时间
+		end = now
+		if idle := now - c.LastActive; idle > c.MaxIdle {
+			c.MaxIdle = idle
+		}
+	}
+	c.Duration = end - c.OpenTime
+	info := c.ConnInfo
+	if c.opened && c.closed && c.Duration < shortConnTime.Microseconds() {
+		stat.ShortConnectionSum++
+		churnStats(stat, c.clientIp).ShortLived++
+		stat.tmpShortConns = keepConns(stat.tmpShortConns, &info, shortConnLess)
+	}
+	if c.MaxIdle >= idleConnTime.Microseconds() {
+		stat.tmpIdleConns = keepConns(stat.tmpIdleConns, &info, idleConnLess)
+	}
+}
+
+// shortConnLess 存活时间短的在前
+func shortConnLess(a, b *ConnInfo) bool {
+	return a.Duration < b.Duration
+}
+
+// idleConnLess 空闲时间长的在前
+func idleConnLess(a, b *ConnInfo) bool {
+	return a.MaxIdle > b.MaxIdle
+}
+
+// keepConns 只保留排在前面的 maxConnReport 条，超过两倍时排序裁剪
+func keepConns(conns []*ConnInfo, c *ConnInfo, less func(a, b *ConnInfo) bool) []*ConnInfo {
+	conns = append(conns, c)
+	if len(conns) >= maxConnReport*2 {
+		conns = topConns(conns, maxConnReport, less)
+	}
+	return conns
+}
+
+func topConns(conns []*ConnInfo, topNum int, less func(a, b *ConnInfo) bool) []*ConnInfo {
+	sort.Slice(conns, func(i, j int) bool { return less(conns[i], conns[j]) })
+	if len(conns) > topNum {
+		conns = conns[:topNum]
+	}
+	return conns
+}
+
+func aggregationConnection(stat *OverallStats, newStat *OverallStats) {
+	stat.ShortConnectionSum += newStat.ShortConnectionSum
+	for key, value := range newStat.tmpChurn {
+		s := churnStats(stat, key)
+		s.Opened += value.Opened
+		s.Closed += value.Closed
+		s.ShortLived += value.ShortLived
+	}
+	for _, c := range newStat.tmpShortConns {
+		info := *c
+		stat.tmpShortConns = keepConns(stat.tmpShortConns, &info, shortConnLess)
+	}
+	for _, c := range newStat.tmpIdleConns {
+		info := *c
+		stat.tmpIdleConns = keepConns(stat.tmpIdleConns, &info, idleConnLess)
+	}
+}
+
+func analysisConnection(stat *OverallStats, topNum int) {
+	seconds := float64(stat.MonitorEndTime-stat.MonitorStartTime) / 1000 / 1000
+	for _, s := range stat.tmpChurn {
+		if seconds > 0 {
+			s.Rate = Decimal(float64(s.Opened) / seconds)
+		}
+		stat.ClientChurn = append(stat.ClientChurn, s)
+	}
+	sort.Slice(stat.ClientChurn, func(i, j int) bool {
+		if stat.ClientChurn[i].Opened != stat.ClientChurn[j].Opened {
+			return stat.ClientChurn[i].Opened > stat.ClientChurn[j].Opened
+		}
+		return stat.ClientChurn[i].Closed > stat.ClientChurn[j].Closed
+	})
+	if len(stat.ClientChurn) > topNum {
+		stat.ClientChurn = stat.ClientChurn[:topNum]
+	}
+	stat.ShortConnections = topConns(stat.tmpShortConns, topNum, shortConnLess)
+	stat.IdleConnections = topConns(stat.tmpIdleConns, topNum, idleConnLess)
+}
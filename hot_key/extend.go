@@ -3,6 +3,7 @@ package hotkeys
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -209,3 +210,19 @@ func addKv(kv []*KV, key string, value int64) []*KV {
 	})
 	return kvs
 }
+
+// topKv 取 map 中值最大的 topNum 个，由大到小排序
+func topKv(m map[string]int64, topNum int) []*KV {
+	kvs := make([]*KV, 0, len(m))
+	for key, value := range m {
+		kvs = append(kvs, &KV{
+			Key:   key,
+			Value: value,
+		})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Value > kvs[j].Value })
+	if len(kvs) > topNum {
+		kvs = kvs[:topNum]
+	}
+	return kvs
+}
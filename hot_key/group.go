@@ -0,0 +1,152 @@
+package hotkeys
+
+import (
+	"sort"
+	"strings"
+)
+
+var maxGroups = 1000 // 单线程最多记录的 db 或客户端名称数量，超过时替换访问最少的分组
+
+const maxGroupKeys = 10000 // 每组保留的 key 数量，超过两倍时裁剪为访问最多的部分
+
+// GroupStats 按 db 或客户端名称分组的访问统计
+type GroupStats struct {
+	Key         string        `json:"key"`          // db 编号或客户端名称
+	Calls       int64         `json:"calls"`        // 访问次数
+	TopKeys     []*KV         `json:"top_keys"`     // 组内使用最多的key
+	TopCommands []*KV         `json:"top_commands"` // 组内使用最多的命令
+	Latency     *LatencyStats `json:"latency"`      // 组内匹配到响应的请求耗时分布
+	keys        map[string]int64
+	commands    map[string]int64
+	latency     *latencyHistogram
+}
+
+func newGroupStats(key string) *GroupStats {
+	return &GroupStats{
+		Key:      key,
+		keys:     map[string]int64{},
+		commands: map[string]int64{},
+		latency:  newLatencyHistogram(),
+	}
+}
+
+// groupStats 获取分组，分组过多时替换访问最少的分组，hitters 为空时不限制数量，用于聚合
+func groupStats(groups map[string]*GroupStats, hitters *spaceSaving, key string) *GroupStats {
+	if hitters != nil {
+		if evicted, full := hitters.admit(key, 1); full {
+			delete(groups, evicted)
+		}
+	}
+	g, ok := groups[key]
+	if !ok {
+		g = newGroupStats(key)
+		groups[key] = g
+	}
+	return g
+}
+
+// groupInfo 按命令所在的 db、客户端名称和用户统计
+func groupInfo(c *redisCommand, stat *OverallStats) {
+	if c.db != "" {
+		groupCommand(groupStats(stat.tmpDbStats, stat.dbHitters, c.db), stat.dbKeyHitters, c)
+	}
+	if c.name != "" {
+		groupCommand(groupStats(stat.tmpNameStats, stat.nameHitters, c.name), stat.nameKeyHitters, c)
+	}
+	if c.user != "" {
+		if foundKv(stat.UserCall, c.user) {
+			modifyKv(stat.UserCall, c.user, 1)
+		} else {
+			stat.UserCall = addKv(stat.UserCall, c.user, 1)
+		}
+	}
+}
+
+// groupCommand 统计分组内的访问，近似统计时全部分组的 key 共用 hitters
+func groupCommand(g *GroupStats, hitters *spaceSaving, c *redisCommand) {
+	g.Calls++
+	g.commands[c.cmd]++
+	if c.key == "" {
+		return
+	}
+	if hitters != nil {
+		hitters.add(g.Key+" "+c.redisCmd, 1)
+	} else {
+		g.keys[c.redisCmd]++
+		if len(g.keys) >= maxGroupKeys*2 {
+			g.keys = trimCounter(g.keys, maxGroupKeys)
+		}
+	}
+}
+
+// groupLatencyInfo 记录分组的请求耗时
+func groupLatencyInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	if g, ok := stat.tmpDbStats[c.db]; ok && c.db != "" {
+		g.latency.record(execTime)
+	}
+	if g, ok := stat.tmpNameStats[c.name]; ok && c.name != "" {
+		g.latency.record(execTime)
+	}
+}
+
+func aggregationGroups(groups map[string]*GroupStats, newGroups map[string]*GroupStats) {
+	for key, value := range newGroups {
+		g := groupStats(groups, nil, key)
+		g.Calls += value.Calls
+		for k, num := range value.keys {
+			g.keys[k] += num
+		}
+		for k, num := range value.commands {
+			g.commands[k] += num
+		}
+		g.latency.merge(value.latency)
+	}
+}
+
+func aggregationGroup(stat *OverallStats, newStat *OverallStats) {
+	aggregationGroups(stat.tmpDbStats, newStat.tmpDbStats)
+	aggregationGroups(stat.tmpNameStats, newStat.tmpNameStats)
+	for _, value := range newStat.UserCall {
+		if foundKv(stat.UserCall, value.Key) {
+			modifyKv(stat.UserCall, value.Key, value.Value)
+		} else {
+			stat.UserCall = addKv(stat.UserCall, value.Key, value.Value)
+		}
+	}
+}
+
+// analysisGroups 按访问次数排序，每组输出 top key、top 命令和耗时分布
+func analysisGroups(groups map[string]*GroupStats, hitters *spaceSaving, topNum int) []*GroupStats {
+	if hitters != nil {
+		// 近似统计时从共用的 hitters 中取出每组的 key，db 和客户端名称不含空格
+		for key, e := range hitters.entries {
+			name, redisCmd, _ := strings.Cut(key, " ")
+			if g, ok := groups[name]; ok {
+				g.keys[redisCmd] = e.count
+			}
+		}
+	}
+	res := make([]*GroupStats, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Calls > res[j].Calls })
+	if len(res) > topNum {
+		res = res[:topNum]
+	}
+	for _, g := range res {
+		g.TopKeys = topKv(g.keys, topNum)
+		g.TopCommands = topKv(g.commands, topNum)
+		g.Latency = g.latency.summary(g.Key)
+	}
+	return res
+}
+
+func analysisGroup(stat *OverallStats, topNum int) {
+	stat.DbStats = analysisGroups(stat.tmpDbStats, stat.dbKeyHitters, topNum)
+	stat.ClientNameStats = analysisGroups(stat.tmpNameStats, stat.nameKeyHitters, topNum)
+	sort.Slice(stat.UserCall, func(i, j int) bool { return stat.UserCall[i].Value > stat.UserCall[j].Value })
+	if len(stat.UserCall) > topNum {
+		stat.UserCall = stat.UserCall[:topNum]
+	}
+}
@@ -0,0 +1,270 @@
+package hotkeys
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// hitterEntrySize 估算一个计数项占用的内存，不含 key 本身
+const hitterEntrySize = 96
+
+// ApproxStats 近似统计的误差范围，精确统计时为空
+type ApproxStats struct {
+	KeyCapacity        int   `json:"key_capacity"`         // 每个线程最多保留的 key 数量
+	KeyErrorBound      int64 `json:"key_error_bound"`      // top keys 访问次数最大高估值，未列出的 key 访问次数不超过该值
+	ReplyErrorBound    int64 `json:"reply_error_bound"`    // top reply keys 响应字节数最大高估值
+	TransferErrorBound int64 `json:"transfer_error_bound"` // top transfer keys 传输字节数最大高估值
+	PrefixErrorBound   int64 `json:"prefix_error_bound"`   // top prefixes 访问次数最大高估值
+	TopKeysError       []*KV `json:"top_keys_error"`       // top keys 中每个 key 访问次数的高估上限
+}
+
+// hitterCounters 每个线程按 capacity 限制的表的个数：key 访问次数、响应字节数、传输字节数、前缀访问次数、前缀未命中、
+// 前缀耗时、前缀命令类别、阻塞命令 key、db 和客户端名称分组内的 key，以及最近两个窗口的 key 和前缀，窗口裁剪前最多为两倍
+const hitterCounters = 18
+
+// hitterCapacity 按内存上限计算每个计数器保留的 key 数量
+func hitterCapacity(memory int64, threadNum uint32, cmdLen int) int {
+	if threadNum == 0 {
+		threadNum = 1
+	}
+	capacity := memory / int64(threadNum) / hitterCounters / int64(cmdLen+hitterEntrySize)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return int(capacity)
+}
+
+// newHitters 开启近似统计，代替按 key 和前缀统计的 map
+func newHitters(stat *OverallStats, capacity int) {
+	stat.topHitters = newSpaceSaving(capacity)
+	stat.replyHitters = newSpaceSaving(capacity)
+	stat.transferHitters = newSpaceSaving(capacity)
+	stat.prefixHitters = newSpaceSaving(capacity)
+	stat.missHitters = newSpaceSaving(capacity)
+	// 其它按 key 或前缀统计的表同样不超过 capacity
+	stat.latencyHitters = newSpaceSaving(min(capacity, maxLatencyPrefix))
+	stat.classHitters = newSpaceSaving(min(capacity, maxClassPrefixes))
+	stat.blockingHitters = newSpaceSaving(min(capacity, maxBlockingKeys))
+	stat.dbKeyHitters = newSpaceSaving(capacity)
+	stat.nameKeyHitters = newSpaceSaving(capacity)
+}
+
+type hitterEntry struct {
+	key   string
+	count int64 // 估计值，不小于真实值
+	err   int64 // 最大高估值
+	hits  int64 // 进入统计后的累加次数，count-err 是这期间累加的准确值
+	aux   int64 // 进入统计后的附加计数，例如前缀未命中次数
+	index int
+}
+
+// hitterHeap 按 count 排序的最小堆
+type hitterHeap []*hitterEntry
+
+func (h hitterHeap) Len() int           { return len(h) }
+func (h hitterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hitterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *hitterHeap) Push(x interface{}) {
+	e := x.(*hitterEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *hitterHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// spaceSaving Space-Saving 算法统计 heavy hitter，最多保留 capacity 个 key
+// 计数满了之后新 key 替换计数最小的 key，并继承它的计数作为误差
+type spaceSaving struct {
+	capacity int
+	entries  map[string]*hitterEntry
+	heap     hitterHeap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		entries:  make(map[string]*hitterEntry),
+	}
+}
+
+// add 累加 key 的计数，返回 key 的计数项，替换的计数项附加计数清零
+func (s *spaceSaving) add(key string, n int64) *hitterEntry {
+	if e, ok := s.entries[key]; ok {
+		e.count += n
+		e.hits++
+		heap.Fix(&s.heap, e.index)
+		return e
+	}
+	if len(s.heap) < s.capacity {
+		e := &hitterEntry{key: key, count: n, hits: 1}
+		s.entries[key] = e
+		heap.Push(&s.heap, e)
+		return e
+	}
+	e := s.heap[0]
+	delete(s.entries, e.key)
+	e.key, e.err, e.hits, e.aux = key, e.count, 1, 0
+	e.count += n
+	s.entries[key] = e
+	heap.Fix(&s.heap, 0)
+	return e
+}
+
+// coldest 计数满了之后新 key 会替换的 key
+func (s *spaceSaving) coldest() (string, bool) {
+	if len(s.heap) < s.capacity || len(s.heap) == 0 {
+		return "", false
+	}
+	return s.heap[0].key, true
+}
+
+// admit 累加 key 的计数，有上限的表按它选择保留的 key
+// 表满时返回被新 key 替换的 key，调用方从表中删除，新 key 继承它的计数，后出现的热点 key 也能保留
+func (s *spaceSaving) admit(key string, n int64) (string, bool) {
+	evicted, full := "", false
+	if _, ok := s.entries[key]; !ok {
+		evicted, full = s.coldest()
+	}
+	s.add(key, n)
+	return evicted, full
+}
+
+// min 未保留的 key 计数上限，未满时为精确统计
+func (s *spaceSaving) min() int64 {
+	if len(s.heap) < s.capacity || len(s.heap) == 0 {
+		return 0
+	}
+	return s.heap[0].count
+}
+
+// merge 合并另一个线程的统计，缺少的 key 按对方的最小计数估计，结果仍是上界
+func (s *spaceSaving) merge(o *spaceSaving) {
+	sMin, oMin := s.min(), o.min()
+	merged := make([]*hitterEntry, 0, len(s.entries)+len(o.entries))
+	for key, e := range s.entries {
+		if oe, ok := o.entries[key]; ok {
+			merged = append(merged, &hitterEntry{key: key, count: e.count + oe.count, err: e.err + oe.err, hits: e.hits + oe.hits, aux: e.aux + oe.aux})
+		} else {
+			merged = append(merged, &hitterEntry{key: key, count: e.count + oMin, err: e.err + oMin, hits: e.hits, aux: e.aux})
+		}
+	}
+	for key, oe := range o.entries {
+		if _, ok := s.entries[key]; !ok {
+			merged = append(merged, &hitterEntry{key: key, count: oe.count + sMin, err: oe.err + sMin, hits: oe.hits, aux: oe.aux})
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].count > merged[j].count })
+	if len(merged) > s.capacity {
+		merged = merged[:s.capacity]
+	}
+	s.entries = make(map[string]*hitterEntry, len(merged))
+	s.heap = s.heap[:0]
+	for _, e := range merged {
+		s.entries[e.key] = e
+		s.heap = append(s.heap, e)
+	}
+	for i, e := range s.heap {
+		e.index = i
+	}
+	heap.Init(&s.heap)
+}
+
+// top 估计值最大的 topNum 个，由大到小排序
+func (s *spaceSaving) top(topNum int) []*hitterEntry {
+	res := make([]*hitterEntry, len(s.heap))
+	copy(res, s.heap)
+	sort.Slice(res, func(i, j int) bool { return res[i].count > res[j].count })
+	if len(res) > topNum {
+		res = res[:topNum]
+	}
+	return res
+}
+
+func aggregationHitters(stat *OverallStats, newStat *OverallStats) {
+	if stat.topHitters != nil && newStat.topHitters != nil {
+		stat.topHitters.merge(newStat.topHitters)
+	}
+	if stat.replyHitters != nil && newStat.replyHitters != nil {
+		stat.replyHitters.merge(newStat.replyHitters)
+	}
+	if stat.transferHitters != nil && newStat.transferHitters != nil {
+		stat.transferHitters.merge(newStat.transferHitters)
+	}
+	if stat.prefixHitters != nil && newStat.prefixHitters != nil {
+		stat.prefixHitters.merge(newStat.prefixHitters)
+	}
+	if stat.missHitters != nil && newStat.missHitters != nil {
+		stat.missHitters.merge(newStat.missHitters)
+	}
+	if stat.dbKeyHitters != nil && newStat.dbKeyHitters != nil {
+		stat.dbKeyHitters.merge(newStat.dbKeyHitters)
+	}
+	if stat.nameKeyHitters != nil && newStat.nameKeyHitters != nil {
+		stat.nameKeyHitters.merge(newStat.nameKeyHitters)
+	}
+}
+
+// analysisPrefixHitters 近似统计时按 prefixHitters 生成 TopPrefixes，需要在 TopPrefixes 排序截断之前调用
+func analysisPrefixHitters(stat *OverallStats, topNum int) {
+	if stat.prefixHitters == nil {
+		return
+	}
+	stat.TopPrefixes = []*KV{}
+	for _, e := range stat.prefixHitters.top(topNum) {
+		stat.TopPrefixes = addKv(stat.TopPrefixes, e.key, e.count)
+	}
+}
+
+// missHitterRatios 近似统计时按 missHitters 计算未命中率，只保留 GET 次数最多的前缀
+// 次数和未命中次数都按前缀进入统计之后的准确值计算
+func missHitterRatios(stat *OverallStats) []*HitRatio {
+	ratios := make([]*HitRatio, 0, len(stat.missHitters.entries))
+	for key, e := range stat.missHitters.entries {
+		if total := e.count - e.err; total > 0 {
+			ratios = append(ratios, &HitRatio{Key: key, Total: total, Miss: e.aux})
+		}
+	}
+	return ratios
+}
+
+func analysisHitters(stat *OverallStats, topNum int) {
+	if stat.topHitters == nil {
+		return
+	}
+	stat.Approx = &ApproxStats{
+		KeyCapacity:        stat.topHitters.capacity,
+		KeyErrorBound:      stat.topHitters.min(),
+		ReplyErrorBound:    stat.replyHitters.min(),
+		TransferErrorBound: stat.transferHitters.min(),
+		PrefixErrorBound:   stat.prefixHitters.min(),
+		TopKeysError:       []*KV{},
+	}
+	stat.TopKeys = []*KV{}
+	for _, e := range stat.topHitters.top(topNum) {
+		stat.TopKeys = addKv(stat.TopKeys, e.key, e.count)
+		stat.Approx.TopKeysError = addKv(stat.Approx.TopKeysError, e.key, e.err)
+	}
+	stat.TopReplyKeys = []*KV{}
+	for _, e := range stat.replyHitters.top(topNum) {
+		stat.TopReplyKeys = addKv(stat.TopReplyKeys, e.key, e.count)
+	}
+	stat.TopTransferKeys = []*KV{}
+	for _, e := range stat.transferHitters.top(topNum) {
+		stat.TopTransferKeys = addKv(stat.TopTransferKeys, e.key, e.count)
+	}
+	// 平均响应大小按 key 进入统计之后的准确值计算
+	avg := make(map[string]int64, len(stat.replyHitters.entries))
+	for key, e := range stat.replyHitters.entries {
+		avg[key] = (e.count - e.err) / e.hits
+	}
+	stat.TopAvgReplyKeys = topKv(avg, topNum)
+}
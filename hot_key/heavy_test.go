@@ -0,0 +1,154 @@
+package hotkeys
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	// 两个线程各自统计，热点 key 加上大量只出现一次的 key
+	exact := map[string]int64{}
+	workers := []*spaceSaving{newSpaceSaving(50), newSpaceSaving(50)}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := "cold:" + strconv.Itoa(i)
+		if i%4 == 0 {
+			key = "hot:" + strconv.Itoa(r.Intn(5))
+		}
+		exact[key]++
+		workers[i%2].add(key, 1)
+	}
+	merged := newSpaceSaving(50)
+	for _, w := range workers {
+		merged.merge(w)
+	}
+	top := merged.top(5)
+	if len(top) != 5 {
+		t.Fatalf("got %d hitters, want 5", len(top))
+	}
+	for _, e := range top {
+		if e.key[:4] != "hot:" {
+			t.Fatalf("got %s in top", e.key)
+		}
+		// 估计值是上界，减去误差是下界
+		if e.count < exact[e.key] || e.count-e.err > exact[e.key] {
+			t.Fatalf("%s estimate %d err %d, exact %d", e.key, e.count, e.err, exact[e.key])
+		}
+	}
+	if bound := merged.min(); bound < 1 || bound > 20000/50*2 {
+		t.Fatalf("got error bound %d", bound)
+	}
+}
+
+func TestSpaceSavingExact(t *testing.T) {
+	s := newSpaceSaving(10)
+	s.add("a", 3)
+	s.add("b", 1)
+	s.add("a", 2)
+	if s.min() != 0 {
+		t.Fatalf("got min %d before full", s.min())
+	}
+	top := s.top(10)
+	if len(top) != 2 || top[0].key != "a" || top[0].count != 5 || top[0].err != 0 {
+		t.Fatalf("got top %+v", top[0])
+	}
+	if got := hitterCapacity(64<<20, 4, 100); got != 64<<20/4/hitterCounters/(100+hitterEntrySize) {
+		t.Fatalf("got capacity %d", got)
+	}
+}
+
+func TestApproxPrefixes(t *testing.T) {
+	stat := newOverallStats()
+	newHitters(stat, 4)
+	for i := 0; i < 1000; i++ {
+		key := "cold" + strconv.Itoa(i) + ":1"
+		if i%2 == 0 {
+			key = "hot:" + strconv.Itoa(i)
+		}
+		c := newRedisCommand([]string{"GET", key}, 100, 0)
+		commandInfo(c, "10.0.0.2", "ipv4", "10.0.0.2:40000", "10.0.0.1:6379", stat, nil)
+		reply := &respValue{kind: '$'}
+		if i%4 == 0 {
+			reply.isNil = true
+		}
+		replyInfo(c, reply, stat)
+	}
+	// 前缀和未命中率只保留计数器容量个前缀
+	if len(stat.TopPrefixes) != 0 || len(stat.prefixHitters.entries) != 4 || len(stat.tmpMissPrefixes) != 0 || len(stat.missHitters.entries) != 4 {
+		t.Fatalf("got %d prefixes, %d prefix hitters, %d miss hitters", len(stat.TopPrefixes), len(stat.prefixHitters.entries), len(stat.missHitters.entries))
+	}
+	analysisPrefixHitters(stat, 1)
+	if len(stat.TopPrefixes) != 1 || stat.TopPrefixes[0].Key != "hot" || stat.TopPrefixes[0].Value < 500 {
+		t.Fatalf("got top prefixes %+v", stat.TopPrefixes)
+	}
+	analysisReply(stat, 1)
+	if len(stat.MissPrefixes) != 1 || stat.MissPrefixes[0].Key != "hot" || stat.MissPrefixes[0].Ratio != 0.5 {
+		t.Fatalf("got miss prefixes %+v", stat.MissPrefixes)
+	}
+}
+
+func TestApproxBoundedTables(t *testing.T) {
+	const capacity = 4
+	w := newTestWorker(t, "10.0.0.1:6379")
+	w.stats[0] = newSessionStats(&HotKeyOptions{Window: time.Second, Top: 10}, capacity)
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	w.feed(
+		conn.packet(t, true, command("CLIENT", "SETNAME", "app")+command("SELECT", "1"), start),
+		conn.packet(t, false, "+OK\r\n+OK\r\n", start),
+	)
+	var requests, replies strings.Builder
+	// key 和前缀的数量远超计数器容量
+	for i := 0; i < 200; i++ {
+		n := strconv.Itoa(i)
+		requests.WriteString(command("GET", "p"+n+":k") + command("BLPOP", "q"+n+":k", "1"))
+		replies.WriteString("$-1\r\n*-1\r\n")
+	}
+	requests.WriteString(command("GET", "moved"))
+	replies.WriteString("-MOVED 99999 10.0.0.3:6379\r\n")
+	w.feed(
+		conn.packet(t, true, requests.String(), start),
+		conn.packet(t, false, replies.String(), start.Add(time.Millisecond)),
+	)
+
+	if stat.TotalAccessSum < 400 {
+		t.Fatalf("got %d commands", stat.TotalAccessSum)
+	}
+	if len(stat.tmpTopKeys)+len(stat.tmpReplyBytes)+len(stat.tmpReplyCount)+len(stat.tmpTransferBytes)+len(stat.tmpMissPrefixes)+len(stat.TopPrefixes) != 0 {
+		t.Fatalf("got exact key tables in approx mode")
+	}
+	for name, n := range map[string]int{
+		"prefix latency":   len(stat.tmpPrefixLatency),
+		"prefix classes":   len(stat.tmpPrefixClasses),
+		"blocking keys":    len(stat.tmpBlocking),
+		"db keys":          len(stat.dbKeyHitters.entries),
+		"client name keys": len(stat.nameKeyHitters.entries),
+	} {
+		if n > capacity {
+			t.Fatalf("got %d %s, want at most %d", n, name, capacity)
+		}
+	}
+	for _, g := range stat.tmpDbStats {
+		if len(g.keys) != 0 {
+			t.Fatalf("got %d keys in db %s", len(g.keys), g.Key)
+		}
+	}
+	for _, win := range stat.windows.windows {
+		if len(win.keys) >= capacity*2 || len(win.prefixes) >= capacity*2 {
+			t.Fatalf("got %d keys %d prefixes in window", len(win.keys), len(win.prefixes))
+		}
+	}
+	for slot := range stat.tmpSlots {
+		if slot < 0 || slot >= clusterSlots {
+			t.Fatalf("got slot %d", slot)
+		}
+	}
+	analysisGroup(stat, 10)
+	if len(stat.DbStats) != 1 || stat.DbStats[0].Key != "1" || len(stat.DbStats[0].TopKeys) == 0 {
+		t.Fatalf("got db stats %+v", stat.DbStats)
+	}
+}
@@ -16,7 +16,15 @@ func TestShowHotKeys(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	info, err := ShowHotKeys(nil, "", 10, 7775, 200, 10, pcapFile, false, "10.192.102.3", 5)
+	info, err := ShowHotKeys(nil, &HotKeyOptions{
+		MonitorTime: 10,
+		Port:        7775,
+		CmdLen:      200,
+		Top:         10,
+		PcapFile:    pcapFile,
+		HostIp:      "10.192.102.3",
+		ThreadNum:   5,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
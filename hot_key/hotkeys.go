@@ -3,15 +3,13 @@ package hotkeys
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/reassembly"
 	log "github.com/sirupsen/logrus"
-	"hash/fnv"
 	"os"
-	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -22,28 +20,118 @@ var (
 	snapshotLen int32 = 65535
 	timeout           = 30 * time.Second
 	separators        = ":;,_- "
+	// defaultQueueSize 每个分析线程默认的队列长度，队列中只保存 TCP 段
+	defaultQueueSize = 100000
 )
 
 type OverallStats struct {
 	// 概览
 
-	ActiveProcessed  uint64  `json:"active_processed"`  // 在线活跃线程数
-	TotalAccessSum   int64   `json:"total_sum"`         // 总访问次数
-	TotalAccessTime  int64   `json:"total_access_time"` // 总访问时间，Microsecond 微妙
-	CommandsSec      float64 `json:"commands_sec"`      // 平均每秒访问次数
-	TopPrefixes      []*KV   `json:"top_prefixes"`      // 前缀访问次数最多的
-	TopKeys          []*KV   `json:"top_keys"`          // top keys 使用最多的key
-	TopCommands      []*KV   `json:"top_commands"`      // 使用最多的命令。 key 次数
-	HeaviestCommands []*KV   `json:"heaviest_commands"` // 命令类型耗时 Microsecond 微妙
-	SlowestCalls     []*KV   `json:"slowest_calls"`     // 慢命令top
-	IPV4Call         []*KV   `json:"ipv4_call"`         // IP 访问次数分布 top 10
+	ActiveProcessed     uint64              `json:"active_processed"`      // 在线活跃线程数
+	TotalAccessSum      int64               `json:"total_sum"`             // 总访问次数
+	TotalAccessTime     int64               `json:"total_access_time"`     // 总访问时间，Microsecond 微妙
+	CommandsSec         float64             `json:"commands_sec"`          // 平均每秒访问次数
+	TopPrefixes         []*KV               `json:"top_prefixes"`          // 前缀访问次数最多的
+	CommandClasses      []*KV               `json:"command_classes"`       // 按读、写、删除、过期、管理类别统计的访问次数
+	PrefixClasses       []*PrefixClassStats `json:"prefix_classes"`        // 访问次数最多的前缀按命令类别的访问次数
+	ReadMostlyPrefixes  []*PrefixClassStats `json:"read_mostly_prefixes"`  // 读多写少，适合客户端缓存的前缀
+	WriteHeavyPrefixes  []*PrefixClassStats `json:"write_heavy_prefixes"`  // 写命令比例高的前缀
+	TopKeys             []*KV               `json:"top_keys"`              // top keys 使用最多的key
+	TopCommands         []*KV               `json:"top_commands"`          // 使用最多的命令。 key 次数
+	HeaviestCommands    []*KV               `json:"heaviest_commands"`     // 命令类型耗时 Microsecond 微妙
+	SlowestCalls        []*KV               `json:"slowest_calls"`         // 慢命令top
+	IPV4Call            []*KV               `json:"ipv4_call"`             // IPv4 客户端 IP 访问次数分布
+	ClientCall          []*KV               `json:"client_call"`           // 客户端 IP 访问次数分布，包含 IPv4 和 IPv6
+	ClientFamily        []*KV               `json:"client_family"`         // 按 ipv4、ipv6 统计的访问次数
+	DbStats             []*GroupStats       `json:"db_stats"`              // 按 db 统计的访问，不知道 db 的命令不统计
+	ClientNameStats     []*GroupStats       `json:"client_name_stats"`     // 按 CLIENT SETNAME 客户端名称统计的访问
+	UserCall            []*KV               `json:"user_call"`             // 按 AUTH 和 HELLO 认证用户统计的访问次数
+	Transactions        TransactionStats    `json:"transactions"`          // MULTI/EXEC 事务统计
+	PipelineDepth       []*KV               `json:"pipeline_depth"`        // 客户端最大的 pipeline 深度，等待响应的命令数
+	TopScripts          []*ScriptStats      `json:"top_scripts"`           // 调用次数最多的 lua 脚本和函数
+	PubSubChannels      []*ChannelStats     `json:"pubsub_channels"`       // 发布和推送字节数最多的频道
+	PubSubPatterns      []*ChannelStats     `json:"pubsub_patterns"`       // 推送字节数最多的模式订阅
+	BlockingKeys        []*BlockingStats    `json:"blocking_keys"`         // 等待时间最长的阻塞命令 key，不计入 SlowestCalls
+	BlockingLatency     []*LatencyStats     `json:"blocking_latency"`      // 阻塞命令的等待时间分布，不计入 CommandTimes 的分位数
+	RiskyCommands       []*KV               `json:"risky_commands"`        // 按原因统计的危险和 O(N) 命令次数
+	RiskyCalls          []*RiskyCall        `json:"risky_calls"`           // 耗时最长的危险和 O(N) 命令调用
+	Network             NetworkStats        `json:"network"`               // TCP 重传、乱序、零窗口、RST 和握手耗时
+	ClientNetwork       []*NetworkStats     `json:"client_network"`        // 网络异常最多的客户端 IP
+	ServerNetwork       []*NetworkStats     `json:"server_network"`        // 按 redis 地址统计的网络质量
+	TotalErrorSum       int64               `json:"total_error_sum"`       // 错误响应总数
+	ErrorCommands       []*KV               `json:"error_commands"`        // 命令错误次数，key 为 "命令 错误前缀"
+	MissPrefixes        []*HitRatio         `json:"miss_prefixes"`         // GET 未命中次数最多的前缀
+	TopReplyKeys        []*KV               `json:"top_reply_keys"`        // 响应字节数最多的key
+	TopTransferKeys     []*KV               `json:"top_transfer_keys"`     // 请求加响应字节数最多的key
+	TopAvgReplyKeys     []*KV               `json:"top_avg_reply_keys"`    // 平均响应字节数最大的key
+	TotalRequestBytes   int64               `json:"total_request_bytes"`   // 请求总字节数
+	TotalReplyBytes     int64               `json:"total_reply_bytes"`     // 响应总字节数
+	CommandRequestBytes []*KV               `json:"command_request_bytes"` // 命令请求字节数
+	CommandReplyBytes   []*KV               `json:"command_reply_bytes"`   // 命令响应字节数
+	TotalMovedSum       int64               `json:"total_moved_sum"`       // 集群 MOVED 重定向总数
+	TotalAskSum         int64               `json:"total_ask_sum"`         // 集群 ASK 重定向总数
+	HotSlots            []*SlotStats        `json:"hot_slots"`             // 访问次数最多的 slot
+	ClientRedirects     []*RedirectRatio    `json:"client_redirects"`      // 客户端重定向次数和比例
+	ShortConnectionSum  int64               `json:"short_connection_sum"`  // 短连接总数
+	ShortConnections    []*ConnInfo         `json:"short_connections"`     // 存活时间最短的连接
+	IdleConnections     []*ConnInfo         `json:"idle_connections"`      // 最长空闲时间最长的连接
+	ClientChurn         []*ChurnStats       `json:"client_churn"`          // 客户端 IP 新建和关闭连接次数
+	Windows             []*WindowStats      `json:"windows"`               // 按时间窗口的 top 快照，每个窗口结束时输出到日志，未开启窗口时为空
+	Approx              *ApproxStats        `json:"approx"`                // 近似统计 key 的误差范围，精确统计时为空
+	CommandLatency      []*LatencyStats     `json:"command_latency"`       // 每个命令的耗时分布
+	PrefixLatency       []*LatencyStats     `json:"prefix_latency"`        // top 前缀的耗时分布
 	CommandTimes
 	Other
-	tmpTopKeys map[string]int64
+	tmpTopKeys         map[string]int64
+	tmpMissPrefixes    map[string]*HitRatio
+	tmpReplyBytes      map[string]int64
+	tmpReplyCount      map[string]int64
+	tmpTransferBytes   map[string]int64
+	tmpSlots           map[int]*SlotStats
+	tmpClientRedirects map[string]*RedirectRatio
+	windows            *windowCounter // 按窗口统计，多个线程时交给聚合统计的 windowMerger
+	windowMerger       *windowMerger  // 合并线程的窗口，结束的窗口在运行中输出
+	topHitters         *spaceSaving   // 近似统计时代替 tmpTopKeys
+	replyHitters       *spaceSaving   // 近似统计时代替 tmpReplyBytes
+	transferHitters    *spaceSaving   // 近似统计时代替 tmpTransferBytes
+	prefixHitters      *spaceSaving   // 近似统计时代替 TopPrefixes
+	missHitters        *spaceSaving   // 近似统计时代替 tmpMissPrefixes，附加计数为未命中次数
+	latency            *latencyHistogram
+	tmpCommandLatency  map[string]*latencyHistogram
+	tmpPrefixLatency   map[string]*latencyHistogram
+	latencyHitters     *spaceSaving           // 按请求数选择记录耗时分布的前缀，和 tmpPrefixLatency 的 key 相同
+	conns              map[string]*connState  // 连接表，按四元组保存
+	idleSessions       map[string]connSession // 空闲超时淘汰的连接只保留会话，连接恢复活跃时还原
+	tmpChurn           map[string]*ChurnStats
+	tmpDbStats         map[string]*GroupStats
+	tmpNameStats       map[string]*GroupStats
+	dbHitters          *spaceSaving // 按访问次数选择保留的 db，和 tmpDbStats 的 key 相同
+	nameHitters        *spaceSaving // 按访问次数选择保留的客户端名称，和 tmpNameStats 的 key 相同
+	dbKeyHitters       *spaceSaving // 近似统计时代替 db 分组内的 key，key 为 db + 空格 + key
+	nameKeyHitters     *spaceSaving // 近似统计时代替客户端名称分组内的 key，key 为名称 + 空格 + key
+	tmpPipelineDepth   map[string]int64
+	tmpScripts         map[string]*ScriptStats
+	scriptHitters      *spaceSaving // 按调用次数选择保留的脚本，和 tmpScripts 的 key 相同
+	tmpChannels        map[string]*ChannelStats
+	channelHitters     *spaceSaving // 按发布和推送次数选择保留的频道，和 tmpChannels 的 key 相同
+	tmpPatterns        map[string]*ChannelStats
+	patternHitters     *spaceSaving // 按订阅和推送次数选择保留的模式，和 tmpPatterns 的 key 相同
+	tmpBlocking        map[string]*BlockingStats
+	blockingHitters    *spaceSaving // 按调用次数选择保留的阻塞命令 key，和 tmpBlocking 的 key 相同
+	tmpBlockingLatency map[string]*latencyHistogram
+	tmpClasses         map[string]int64
+	tmpPrefixClasses   map[string]*PrefixClassStats
+	classHitters       *spaceSaving // 按访问次数选择保留的前缀，和 tmpPrefixClasses 的 key 相同
+	tmpRiskyReasons    map[string]int64
+	tmpRiskyCalls      riskyHeap
+	tmpClientNetwork   map[string]*NetworkStats
+	tmpServerNetwork   map[string]*NetworkStats
+	tmpShortConns      []*ConnInfo
+	tmpIdleConns       []*ConnInfo
 }
 
 type CommandTimes struct {
-	// 请求响应平均耗时
+	// 请求响应耗时分布，按全部匹配到响应的请求计算
 	Median int64 `json:"median"` // 平均耗时
 	P999   int64 `json:"p_999"`  // p999 平均耗时
 	P99    int64 `json:"p_99"`   // p99 平均耗时
@@ -52,27 +140,107 @@ type CommandTimes struct {
 	P85    int64 `json:"p_85"`   // p85 平均耗时
 	P80    int64 `json:"p_80"`   // p90 平均耗时
 	P75    int64 `json:"p_75"`   // p75 平均耗时
+	Max    int64 `json:"max"`    // 最大耗时
 }
 
 type Other struct {
-	PacketSum        int64 `json:"packet_sum"`         // 总计算包数量
-	NewConnectNum    int   `json:"new_connect_num"`    // 新建连接数
-	CloseConnectNum  int64 `json:"close_connect_num"`  // 连接断开数
-	DiscardPacketSum int64 `json:"discard_packet_sum"` // 丢弃包数量
-	MonitorStartTime int64 `json:"monitor_start_time"` // 监控开始时间，时间戳，微秒
-	MonitorEndTime   int64 `json:"monitor_end_time"`   // 监控结束时间，时间戳，微秒
+	PacketSum          int64 `json:"packet_sum"`          // 总计算包数量
+	NewConnectNum      int   `json:"new_connect_num"`     // 新建连接数
+	CloseConnectNum    int64 `json:"close_connect_num"`   // 连接断开数
+	DiscardPacketSum   int64 `json:"discard_packet_sum"`  // 丢弃包数量
+	UnmatchedReplySum  int64 `json:"unmatched_reply_sum"` // 未匹配到命令的响应数
+	MonitorStartTime   int64 `json:"monitor_start_time"`  // 监控开始时间，时间戳，微秒
+	MonitorEndTime     int64 `json:"monitor_end_time"`    // 监控结束时间，时间戳，微秒
+	LatencyUnavailable bool  `json:"latency_unavailable"` // 数据源没有响应，耗时相关统计不可用
+	QueueDropSum       int64 `json:"queue_drop_sum"`      // 分析队列满时丢弃的包数量
+	KernelDropSum      int64 `json:"kernel_drop_sum"`     // 内核缓冲区满时 libpcap 丢弃的包数量
+	IfDropSum          int64 `json:"if_drop_sum"`         // 网卡丢弃的包数量
 }
 
 type KV struct {
 	Key   string `json:"key"`
 	Value int64  `json:"value"`
 }
+
+type HitRatio struct {
+	Key   string  `json:"key"`
+	Total int64   `json:"total"` // 读取次数
+	Miss  int64   `json:"miss"`  // 返回 nil 的次数
+	Ratio float64 `json:"ratio"` // 未命中率
+}
 type NetPacket struct {
 	PacketContent gopacket.Packet
 	ReceiveTime   int64
 }
+
+// tcpPacket 分发给分析线程的 TCP 段，只保留分析需要的字段，不持有解码后的整个数据包
+type tcpPacket struct {
+	flow        gopacket.Flow // 网络层地址
+	tcp         layers.TCP
+	ci          gopacket.CaptureInfo
+	receiveTime int64 // 包时间，时间戳，微秒
+	tick        bool  // 分发协程定时发送的时钟，没有数据，空闲的线程也能推进窗口
+}
+
+func newTCPPacket(netLayer gopacket.NetworkLayer, tcp *layers.TCP, ci gopacket.CaptureInfo) *tcpPacket {
+	p := &tcpPacket{
+		flow:        netLayer.NetworkFlow(),
+		tcp:         *tcp,
+		ci:          ci,
+		receiveTime: ci.Timestamp.UnixMicro(),
+	}
+	// 头部和选项在重组中不使用
+	p.tcp.Contents, p.tcp.Options, p.tcp.Padding = nil, nil, nil
+	return p
+}
+
+// enqueue 交给分析线程，离线文件等待队列空闲，读取变慢但不丢包，取消时返回 false
+// 在线抓包不阻塞读取，阻塞会导致 libpcap 缓冲区溢出，队列满时丢弃并返回 false
+func enqueue(ctx context.Context, ch chan *tcpPacket, p *tcpPacket, wait bool) bool {
+	if wait {
+		select {
+		case ch <- p:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case ch <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatchResult 分发协程结束时交回的监控时间和计数
+type dispatchResult struct {
+	startTime  int64 // 离线文件第一个包的时间，在线抓包为 0
+	endTime    int64 // 离线文件最后一个包的时间，在线抓包为结束的时间
+	packetSum  int64 // 没有 TCP 层的包数量，计入 PacketSum
+	queueDrops int64 // 分析队列满时丢弃的包数量
+}
+
+// dropStats 在线抓包结束时读取 libpcap 的丢包数
+func dropStats(handle *pcap.Handle, stat *OverallStats) {
+	if stat.Other.QueueDropSum > 0 {
+		log.Warnf("分析队列已满，丢弃 %d 个包", stat.Other.QueueDropSum)
+	}
+	s, err := handle.Stats()
+	if err != nil {
+		log.Warnf("get pcap stats fail, err: %v", err)
+		return
+	}
+	stat.Other.KernelDropSum = int64(s.PacketsDropped)
+	stat.Other.IfDropSum = int64(s.PacketsIfDropped)
+	if s.PacketsDropped > 0 || s.PacketsIfDropped > 0 {
+		log.Warnf("内核丢弃 %d 个包，网卡丢弃 %d 个包", s.PacketsDropped, s.PacketsIfDropped)
+	}
+}
+
 type FullKey struct {
 	FullCmd     string
+	Args        []string `json:",omitempty"` // 命令的参数，包含空格的参数也能还原，旧文件中没有
 	ReceiveTime int64
 	Src         string
 	Dst         string
@@ -81,20 +249,92 @@ type FullKey struct {
 type link struct {
 	src          string
 	dst          string
-	transmission chan *NetPacket
-	stat         *OverallStats
+	transmission chan *tcpPacket
+	stats        []*OverallStats // 按 endpoint.index 保存每个 redis 实例的统计
+	assembler    *reassembly.Assembler
+}
+
+// HotKeyOptions 热key分析参数
+type HotKeyOptions struct {
+	Device      string        // 在线抓包的网卡
+	MonitorTime int           // 监控时间，秒
+	Port        int           // redis 端口
+	CmdLen      int           // key 最大长度
+	Top         int           // 输出 top 数量
+	PcapFile    *os.File      // 离线分析的 pcap 文件，为空时在线抓包
+	WriteFile   bool          // 命令写入文件
+	HostIp      string        // redis 地址，支持 IPv4、IPv6 和 CIDR，多个用逗号分隔
+	ThreadNum   uint32        // 分析线程数
+	Bpf         string        // BPF 过滤表达式，为空时按端口和地址生成
+	Window      time.Duration // 统计窗口大小，大于0时按包时间统计每个窗口的 top 快照，窗口结束时输出到日志
+	KeyMemory   int64         // 近似统计 key 和前缀的内存上限，字节，0 为精确统计
+	Endpoints   []string      // 多个 redis 实例 ip:port，为空时使用 HostIp 和 Port
+	Separators  string        // key 前缀分隔符，为空时使用默认值
+	CommandLogs []string      // 离线分析的命令日志文件或目录，由 WriteFile 写入
+	StartTime   int64         // 命令日志只分析该时间之后的命令，时间戳，微秒，0 不限制
+	EndTime     int64         // 命令日志只分析该时间之前的命令，时间戳，微秒，0 不限制
+	MonitorFile *os.File      // redis MONITOR 输出，文件或标准输入
+	ProxyListen string        // 代理模式监听地址，例如 :16379
+	ProxyTarget string        // 代理模式转发的 redis 地址 ip:port
+	QueueSize   int           // 每个分析线程的队列长度，0 使用默认值，在线抓包队列满时丢包
+	Redact      bool          // 写入文件时保留 key，其它参数替换为哈希值，密码总是隐藏
+	RedactRules []string      // 写入文件时正则匹配的内容替换为 ***
+}
+
+// hotKeyEndpoints 监控的 redis 实例
+func hotKeyEndpoints(opts *HotKeyOptions) ([]*endpoint, error) {
+	if len(opts.Endpoints) == 0 {
+		ep, err := newEndpoint(0, opts.HostIp, opts.Port)
+		if err != nil {
+			return nil, err
+		}
+		return []*endpoint{ep}, nil
+	}
+	eps, err := parseEndpoints(opts.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if len(eps) == 0 {
+		return nil, fmt.Errorf("no redis endpoint")
+	}
+	return eps, nil
 }
 
-var activeConnection []string
+// newSessionStats 按分析参数创建统计，capacity 为近似统计每个计数器保留的 key 数量
+func newSessionStats(opts *HotKeyOptions, capacity int) *OverallStats {
+	stat := newOverallStats()
+	if opts.Window > 0 {
+		stat.windowMerger = newWindowMerger(opts.Window.Microseconds(), opts.Top)
+		stat.windowMerger.limit = capacity
+		stat.windows = stat.windowMerger.counter()
+	}
+	if capacity > 0 {
+		newHitters(stat, capacity)
+	}
+	return stat
+}
 
-func ShowHotKeys(ctx context.Context, device string, mTime, dPort, cmdLen, top int, pcapFile *os.File, hotKeyWrite bool, hostIp string, threadNum uint32) (map[string]interface{}, error) {
-	overallStat := newOverallStats()
+func ShowHotKeys(ctx context.Context, opts *HotKeyOptions) (map[string]interface{}, error) {
+	threadNum := opts.ThreadNum
+	eps, err := hotKeyEndpoints(opts)
+	if err != nil {
+		return nil, err
+	}
+	var capacity int
+	if opts.KeyMemory > 0 {
+		// 内存上限按线程和实例平分
+		capacity = hitterCapacity(opts.KeyMemory, threadNum*uint32(len(eps)), opts.CmdLen)
+		log.Infof("近似统计 key，每个线程保留 %d 个", capacity)
+	}
+	if opts.Separators != "" {
+		defer setSeparators(opts.Separators)()
+	}
+	overallStat := newSessionStats(opts, capacity)
 	var handle *pcap.Handle
-	var err error
-	if pcapFile != nil {
-		handle, err = pcap.OpenOfflineFile(pcapFile)
+	if opts.PcapFile != nil {
+		handle, err = pcap.OpenOfflineFile(opts.PcapFile)
 	} else {
-		handle, err = pcap.OpenLive(device, snapshotLen, false, timeout)
+		handle, err = pcap.OpenLive(opts.Device, snapshotLen, false, timeout)
 	}
 
 	if err != nil {
@@ -103,61 +343,158 @@ func ShowHotKeys(ctx context.Context, device string, mTime, dPort, cmdLen, top i
 	defer func() {
 		go handle.Close()
 	}()
-	endTime := time.Now().UnixMicro()
+	// 内核中过滤无关的流量
+	filter := opts.Bpf
+	if filter == "" {
+		filter = buildBpfFilter(eps)
+	}
+	log.Infof("bpf filter: %s", filter)
+	if err = handle.SetBPFFilter(filter); err != nil {
+		return nil, fmt.Errorf("set bpf filter %q fail: %v", filter, err)
+	}
 	overallStat.MonitorStartTime = time.Now().UnixMicro()
+	offline := opts.PcapFile != nil
 
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	// 多个实例时先按实例聚合，再合并为整体，窗口在运行中按实例和整体合并
+	var instanceStats []*OverallStats
+	if len(eps) > 1 {
+		for _, ep := range eps {
+			stat := newSessionStats(opts, capacity)
+			if stat.windowMerger != nil {
+				stat.windowMerger.name = " " + ep.name
+				stat.windowMerger.parent = overallStat.windowMerger
+			}
+			instanceStats = append(instanceStats, stat)
+		}
+	}
 	var resourceAllocation map[int]*link = make(map[int]*link)
 	// 初始化资源
 	for i := 0; i < int(threadNum); i++ {
 		resourceAllocation[i] = &link{
 			src:          "",
 			dst:          "",
-			transmission: make(chan *NetPacket, 10000000),
-			stat:         newOverallStats(),
+			transmission: make(chan *tcpPacket, queueSize),
 		}
+		for j := range eps {
+			stat := newSessionStats(opts, capacity)
+			merged := overallStat
+			if instanceStats != nil {
+				merged = instanceStats[j]
+			}
+			if merged.windowMerger != nil {
+				stat.windows, stat.windowMerger = merged.windowMerger.counter(), nil
+			}
+			resourceAllocation[i].stats = append(resourceAllocation[i].stats, stat)
+		}
+	}
+	// 离线文件读取到结尾为止，不受监控时间限制
+	var timeOut <-chan time.Time
+	if !offline {
+		timeOut = time.After(time.Second * time.Duration(opts.MonitorTime))
+	}
+	// 开启窗口时定时给每个线程发送时钟，离线文件按包时间，在线抓包按当前时间
+	var ticker <-chan time.Time
+	if opts.Window > 0 && !offline {
+		t := time.NewTicker(opts.Window)
+		defer t.Stop()
+		ticker = t.C
+	}
+	var lastTick int64
+	tick := func(now int64) {
+		for _, v := range resourceAllocation {
+			enqueue(ctx, v.transmission, &tcpPacket{receiveTime: now, tick: true}, offline)
+		}
+		lastTick = now
+	}
+	var cmdFile *os.File
+	var bufferWrite *commandWriter
+	if opts.WriteFile {
+		policy, err := newRedactPolicy(opts.Redact, opts.RedactRules)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("开始写入文件")
+		cmdFile, err = os.OpenFile(fmt.Sprintf("/tmp/%d_%d.txt", overallStat.MonitorStartTime, eps[0].port), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		// 写入缓存
+		bufferWrite = newCommandWriter(bufio.NewWriter(cmdFile), policy)
+		defer func() {
+			err = bufferWrite.flush()
+			err = cmdFile.Close()
+		}()
 	}
-	timeOut := time.After(time.Second * time.Duration(mTime))
+
+	packets := packetSource.Packets()
+	// 分发协程结束后才能读取它的结果
+	dispatched := make(chan dispatchResult, 1)
 	// var limitNetworkPackageSize int = 1024 << 20
 	go func() {
+		var res dispatchResult
+		defer func() { dispatched <- res }()
 		log.Infof("开始接收网络消息")
-		var networkIp string
-		var hashKey int
+		if ticker != nil {
+			tick(time.Now().UnixMicro())
+		}
+		var threadId int
+		var ok bool
 		var netLayer gopacket.NetworkLayer
+		var tcp *layers.TCP
 		for {
 			select {
 			case <-timeOut:
 				log.Infof("接收网络消息结束")
+				res.endTime = time.Now().UnixMicro()
 				for _, v := range resourceAllocation {
 					close(v.transmission)
 				}
 				log.Infof("结束资源通道")
-				endTime = time.Now().UnixMicro()
 				return
 			case <-ctx.Done():
 				// cc()
 				log.Infof("取消资源通道")
+				res.endTime = time.Now().UnixMicro()
 				return
-			case packet := <-packetSource.Packets():
-				data := &NetPacket{
-					PacketContent: packet,
-					ReceiveTime:   time.Now().UnixMicro(),
+			case now := <-ticker:
+				tick(now.UnixMicro())
+			case packet, more := <-packets:
+				if !more {
+					log.Infof("离线文件读取结束")
+					if res.startTime == 0 {
+						res.endTime = time.Now().UnixMicro()
+					}
+					for _, v := range resourceAllocation {
+						close(v.transmission)
+					}
+					log.Infof("结束资源通道")
+					return
 				}
-				netLayer = data.PacketContent.NetworkLayer()
-				if netLayer != nil {
-					if strings.Contains(netLayer.NetworkFlow().Dst().String(), hostIp) {
-						networkIp = netLayer.NetworkFlow().Src().String()
-						hashKey = hashToBucket(networkIp, threadNum)
-						resourceAllocation[hashKey].transmission <- data
-						//	netLayer.NetworkFlow().
+				if offline {
+					res.endTime = packet.Metadata().Timestamp.UnixMicro()
+					if res.startTime == 0 {
+						res.startTime = res.endTime
 					}
-					if strings.Contains(netLayer.NetworkFlow().Src().String(), hostIp) {
-						networkIp = netLayer.NetworkFlow().Dst().String()
-						hashKey = hashToBucket(networkIp, threadNum)
-						resourceAllocation[hashKey].transmission <- data
+					if opts.Window > 0 && res.endTime-lastTick >= opts.Window.Microseconds() {
+						tick(res.endTime)
+					}
+				}
+				netLayer = packet.NetworkLayer()
+				tcp, _ = packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+				if netLayer != nil && tcp != nil {
+					if threadId, ok = route(netLayer, tcp, eps, threadNum); ok {
+						data := newTCPPacket(netLayer, tcp, packet.Metadata().CaptureInfo)
+						if !enqueue(ctx, resourceAllocation[threadId].transmission, data, offline) && ctx.Err() == nil {
+							res.queueDrops++
+						}
 					}
 				} else {
-					overallStat.Other.PacketSum++
+					res.packetSum++
 				}
 
 			}
@@ -165,29 +502,19 @@ func ShowHotKeys(ctx context.Context, device string, mTime, dPort, cmdLen, top i
 
 	}()
 
-	var cmdFile *os.File
-	var bufferWrite *bufio.Writer
-	if hotKeyWrite {
-		log.Infof("开始写入文件")
-		cmdFile, err = os.OpenFile(fmt.Sprintf("/tmp/%d_%d.txt", overallStat.MonitorStartTime, dPort), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, err
-		}
-		// 写入缓存
-		bufferWrite = bufio.NewWriter(cmdFile)
-		defer func() {
-			err = bufferWrite.Flush()
-			err = cmdFile.Close()
-		}()
-	}
-
 	var wg *sync.WaitGroup = &sync.WaitGroup{}
 	for threadId, resource := range resourceAllocation {
 		wg.Add(1)
 		log.Infof("开始%d线程", threadId)
 		go func(allocate *link, threadId int) {
 			defer wg.Done()
-			var timeDiff = make(map[string]map[string]int64)
+			allocate.assembler = newAssembler(&redisStreamFactory{
+				endpoints: eps,
+				cmdLen:    opts.CmdLen,
+				cmdFile:   bufferWrite,
+				stats:     allocate.stats,
+			})
+			var lastFlush int64
 			for {
 				select {
 				case <-ctx.Done():
@@ -196,29 +523,87 @@ func ShowHotKeys(ctx context.Context, device string, mTime, dPort, cmdLen, top i
 					return
 				case packet := <-allocate.transmission:
 					if packet == nil {
+						allocate.assembler.FlushAll()
 						log.Infof("结束%d线程", threadId)
 						return
 					}
-					PacketInfo(packet, dPort, hostIp, cmdLen, allocate.stat, bufferWrite, timeDiff)
+					if packet.tick {
+						for _, stat := range allocate.stats {
+							advanceWindows(stat, packet.receiveTime)
+						}
+					} else {
+						packetInfo(packet, eps, allocate.stats, allocate.assembler)
+					}
+					// 按包时间刷新长时间等待乱序包的连接
+					if packet.receiveTime-lastFlush >= flushInterval.Microseconds() {
+						now := time.UnixMicro(packet.receiveTime)
+						allocate.assembler.FlushWithOptions(reassembly.FlushOptions{T: now.Add(-flushOlderThan), TC: now.Add(-closeOlderThan)})
+						for _, stat := range allocate.stats {
+							evictConnections(stat, packet.receiveTime)
+						}
+						lastFlush = packet.receiveTime
+					}
 				}
 			}
 		}(resource, threadId)
 	}
 	log.Infof("等待处理线程结束")
 	wg.Wait()
+	res := <-dispatched
+	if res.startTime > 0 {
+		overallStat.MonitorStartTime = res.startTime
+	}
+	endTime := res.endTime
+	overallStat.Other.PacketSum += res.packetSum
+	overallStat.Other.QueueDropSum += res.queueDrops
+	if !offline {
+		dropStats(handle, overallStat)
+	}
 	log.Infof("开始聚合数据")
 	overallStat.MonitorEndTime = endTime
-	aggregation(overallStat, resourceAllocation)
-	analysisResult := analysisCounter(overallStat, top)
+	for _, l := range resourceAllocation {
+		for _, stat := range l.stats {
+			finishConnections(stat, endTime)
+		}
+	}
+	if len(eps) == 1 {
+		aggregation(overallStat, workerStats(resourceAllocation, 0))
+		analysisResult := analysisCounter(overallStat, opts.Top)
+		log.Infof("分析数据结束")
+		return analysisResult, nil
+	}
+	for i, ep := range eps {
+		log.Infof("聚合实例 %s", ep.name)
+		instanceStats[i].MonitorStartTime = overallStat.MonitorStartTime
+		instanceStats[i].MonitorEndTime = overallStat.MonitorEndTime
+		aggregation(instanceStats[i], workerStats(resourceAllocation, i))
+	}
+	aggregation(overallStat, instanceStats)
+	analysisResult := analysisCounter(overallStat, opts.Top)
+	instances := make(map[string]interface{}, len(eps))
+	for i, ep := range eps {
+		instances[ep.name] = analysisCounter(instanceStats[i], opts.Top)
+	}
+	analysisResult["instances"] = instances
 	log.Infof("分析数据结束")
 	return analysisResult, nil
 }
 
+// workerStats 每个线程中一个实例的统计
+func workerStats(resourceAllocation map[int]*link, index int) []*OverallStats {
+	stats := make([]*OverallStats, 0, len(resourceAllocation))
+	for _, l := range resourceAllocation {
+		stats = append(stats, l.stats[index])
+	}
+	return stats
+}
+
 func analysisCounter(overallStat *OverallStats, topNum int) map[string]interface{} {
 	// 结束监听
 	// overallStat.MonitorEndTime = time.Now().UnixMicro()
 	// 排序
 	log.Infof("计算排序")
+	analysisPrefixHitters(overallStat, topNum)
 	sort.Slice(overallStat.TopPrefixes, func(i, j int) bool { return overallStat.TopPrefixes[i].Value > overallStat.TopPrefixes[j].Value })
 	for key, value := range overallStat.tmpTopKeys {
 		if len(overallStat.TopKeys) <= topNum {
@@ -241,24 +626,23 @@ func analysisCounter(overallStat *OverallStats, topNum int) map[string]interface
 		return overallStat.HeaviestCommands[i].Value > overallStat.HeaviestCommands[j].Value
 	})
 	sort.Slice(overallStat.IPV4Call, func(i, j int) bool { return overallStat.IPV4Call[i].Value > overallStat.IPV4Call[j].Value })
+	sort.Slice(overallStat.ClientCall, func(i, j int) bool { return overallStat.ClientCall[i].Value > overallStat.ClientCall[j].Value })
 
-	// 由小到大排序，计算P95，P99等值
-	log.Infof("计算P值")
-	sort.Slice(overallStat.SlowestCalls, func(i, j int) bool { return overallStat.SlowestCalls[i].Value < overallStat.SlowestCalls[j].Value })
-	if len(overallStat.SlowestCalls) > 0 {
-		overallStat.P999 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.999)].Value
-		overallStat.P99 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.99)].Value
-		overallStat.P95 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.95)].Value
-		overallStat.P90 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.9)].Value
-		overallStat.P85 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.85)].Value
-		overallStat.P80 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.80)].Value
-		overallStat.P75 = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))*0.75)].Value
-		overallStat.Median = overallStat.SlowestCalls[int(float64(len(overallStat.SlowestCalls))/2)].Value
-	}
 	// 由大到小排序
 	log.Infof("计算Slow")
 	sort.Slice(overallStat.SlowestCalls, func(i, j int) bool { return overallStat.SlowestCalls[i].Value > overallStat.SlowestCalls[j].Value })
 
+	analysisCluster(overallStat, topNum)
+	analysisConnection(overallStat, topNum)
+	analysisGroup(overallStat, topNum)
+	analysisTransaction(overallStat, topNum)
+	analysisScript(overallStat, topNum)
+	analysisPubSub(overallStat, topNum)
+	analysisBlocking(overallStat, topNum)
+	analysisClass(overallStat, topNum)
+	analysisRisky(overallStat, topNum)
+	analysisNetwork(overallStat, topNum)
+
 	if len(overallStat.TopPrefixes) > topNum {
 		overallStat.TopPrefixes = overallStat.TopPrefixes[:topNum]
 	}
@@ -271,10 +655,23 @@ func analysisCounter(overallStat *OverallStats, topNum int) map[string]interface
 	if len(overallStat.IPV4Call) > topNum {
 		overallStat.IPV4Call = overallStat.IPV4Call[:topNum]
 	}
+	if len(overallStat.ClientCall) > topNum {
+		overallStat.ClientCall = overallStat.ClientCall[:topNum]
+	}
+
+	analysisReply(overallStat, topNum)
+	analysisPayload(overallStat, topNum)
+	// 按直方图计算P值
+	log.Infof("计算P值")
+	analysisLatency(overallStat)
+	analysisWindow(overallStat, topNum)
+	analysisHitters(overallStat, topNum)
 
 	// 每秒执行命令数量
 	log.Infof("计算每秒速度")
-	overallStat.CommandsSec = Decimal(float64(overallStat.TotalAccessSum) / float64((overallStat.MonitorEndTime-overallStat.MonitorStartTime)/1000/1000))
+	if duration := overallStat.MonitorEndTime - overallStat.MonitorStartTime; duration > 0 {
+		overallStat.CommandsSec = Decimal(float64(overallStat.TotalAccessSum) / (float64(duration) / 1000 / 1000))
+	}
 	log.Infof("解析json")
 	m := Struct2MapByTag(overallStat, "json")
 	return m
@@ -310,239 +707,308 @@ func PacketInfo(packet gopacket.Packet, dPort int, cmdLen int) {
 	}
 }
 */
-func PacketInfo(packet *NetPacket, dPort int, hostIp string, cmdLen int, stat *OverallStats, cmdFile *bufio.Writer, timeDiff map[string]map[string]int64) {
-	stat.PacketSum++
+// stats 按 endpoint.index 保存每个 redis 实例的统计
+func PacketInfo(packet *NetPacket, eps []*endpoint, stats []*OverallStats, assembler *reassembly.Assembler) {
 	packet.ReceiveTime = packet.PacketContent.Metadata().Timestamp.UnixMicro()
-	tcpLayer := packet.PacketContent.Layer(layers.LayerTypeTCP)
+	tcp, _ := packet.PacketContent.Layer(layers.LayerTypeTCP).(*layers.TCP)
 	netLayer := packet.PacketContent.NetworkLayer()
-	var execTime int64
-	if tcpLayer != nil {
-		tcp, _ := tcpLayer.(*layers.TCP)
-		log.Debugf("FIN %v, SYN %v, RST %v, PSH %v, ACK %v, URG %v, ECE %v, CWR %v, NS %v ", tcp.FIN, tcp.SYN, tcp.RST, tcp.PSH, tcp.ACK, tcp.URG, tcp.ECE, tcp.CWR, tcp.NS)
-		if tcp.DstPort == layers.TCPPort(dPort) || tcp.SrcPort == layers.TCPPort(dPort) {
-
-			Src := netLayer.NetworkFlow().Src().String()
-			Dst := netLayer.NetworkFlow().Dst().String()
-			if tcp.DstPort == layers.TCPPort(dPort) {
-				if !slices.Contains(activeConnection, fmt.Sprintf("%s:%s", Src, tcp.SrcPort.String())) {
-					activeConnection = append(activeConnection, fmt.Sprintf("%s:%s", Src, tcp.SrcPort.String()))
-					stat.ActiveProcessed++
-				}
-			}
-
-			applicationLayer := packet.PacketContent.ApplicationLayer()
-			switch {
-			case tcp.FIN: // 结束连接
-				stat.CloseConnectNum += 1
-			case tcp.SYN: // 建立连接
-				stat.NewConnectNum += 1
-			case tcp.RST: // 连接重置
-				log.Debugf("RST Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			case tcp.PSH && tcp.ACK: // 数据传输
-				log.Debugf("PSH+ACK Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-				if tcp.SrcPort == layers.TCPPort(dPort) {
-					uniqueIdentification := fmt.Sprintf("%s:%s-%s:%s-%d", Dst, tcp.DstPort.String(), Src, tcp.SrcPort.String(), tcp.Seq)
-					if v, ok := timeDiff[uniqueIdentification]; ok {
-						for key, value := range v {
-							redisCmd := strings.Split(key, " ")
-							cmd := redisCmd[0]
-							if strings.HasPrefix(strings.ToLower(cmd), "auth") {
-								// 认证命令不记录
-								continue
-							}
-							execTime = packet.ReceiveTime - value
-							stat.TotalAccessTime += execTime
-							// redisKey := strings.Join(redisCmd[1:], " ")
-							stat.SlowestCalls = addKv(stat.SlowestCalls, key, execTime)
-							if foundKv(stat.HeaviestCommands, cmd) {
-								modifyKv(stat.HeaviestCommands, cmd, execTime)
-							} else {
-								stat.HeaviestCommands = addKv(stat.HeaviestCommands, cmd, execTime)
-							}
+	if tcp != nil && netLayer != nil {
+		packetInfo(newTCPPacket(netLayer, tcp, packet.PacketContent.Metadata().CaptureInfo), eps, stats, assembler)
+	}
+}
 
-						}
+// packetInfo 分析线程处理一个 TCP 段
+func packetInfo(packet *tcpPacket, eps []*endpoint, stats []*OverallStats, assembler *reassembly.Assembler) {
+	tcp, flow := &packet.tcp, packet.flow
+	log.Debugf("FIN %v, SYN %v, RST %v, PSH %v, ACK %v, URG %v, ECE %v, CWR %v, NS %v ", tcp.FIN, tcp.SYN, tcp.RST, tcp.PSH, tcp.ACK, tcp.URG, tcp.ECE, tcp.CWR, tcp.NS)
+	if ep, toServer := matchPacket(eps, flow, tcp); ep != nil {
+		stat := stats[ep.index]
+		stat.PacketSum++
 
-					} else {
-						stat.DiscardPacketSum++
-					}
-				}
+		Src := flow.Src().String()
+		Dst := flow.Dst().String()
+		src := hostPort(Src, tcp.SrcPort)
+		dst := hostPort(Dst, tcp.DstPort)
+		if toServer {
+			connectionInfo(stat, tcp, Src, src, dst, true, packet.receiveTime)
+		} else {
+			connectionInfo(stat, tcp, Dst, dst, src, false, packet.receiveTime)
+		}
 
-			case tcp.PSH:
-				log.Debugf("PSH Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			case tcp.URG:
-				log.Debugf("URG Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			case tcp.ECE:
-				log.Debugf("ECE Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			case tcp.CWR:
-				log.Debugf("CWR Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			case tcp.NS:
-				log.Debugf("NS Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			case tcp.ACK: // 连接响应
-				log.Debugf("ACK  Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			default:
-				log.Debugf("Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
-			}
+		switch {
+		case tcp.FIN: // 结束连接
+			log.Debugf("FIN Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.SYN: // 建立连接
+			log.Debugf("SYN Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.RST: // 连接重置
+			log.Debugf("RST Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.PSH && tcp.ACK: // 数据传输
+			log.Debugf("PSH+ACK Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.PSH:
+			log.Debugf("PSH Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.URG:
+			log.Debugf("URG Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.ECE:
+			log.Debugf("ECE Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.CWR:
+			log.Debugf("CWR Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.NS:
+			log.Debugf("NS Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		case tcp.ACK: // 连接响应
+			log.Debugf("ACK  Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		default:
+			log.Debugf("Src: %s:%s Dst: %s:%s ", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String())
+		}
 
-			if applicationLayer != nil {
-				if tcp.DstPort == layers.TCPPort(dPort) && Dst == hostIp {
-					str := string(applicationLayer.Payload()[:])
-					arr := Split(str, "\r\n")
-					// 获取访问命令
-					var cmd string
-					var fullCmd []string
-					if len(arr) < 3 {
-						log.Warnf("skip command : %s", strings.Join(arr, " "))
-						return
-					}
-					cmd = arr[2]
-					// 需要去除认证
-					if len(arr) >= 5 && !strings.HasPrefix(strings.ToLower(cmd), "auth") {
-						for _, key := range arr {
-							switch {
-							case strings.HasPrefix(key, "$"):
-								continue
-							case strings.HasPrefix(key, "*"):
-								continue
-							case key == "":
-								continue
-							default:
-								fullCmd = append(fullCmd, key)
-							}
-						}
-						if cmdFile != nil {
-							cmdBuf := new(FullKey)
-							cmdBuf.FullCmd = strings.Join(fullCmd, " ")
-							cmdBuf.Src = fmt.Sprintf("%s:%s", Src, tcp.SrcPort.String())
-							cmdBuf.Dst = fmt.Sprintf("%s:%s", Dst, tcp.DstPort.String())
-							cmdBuf.ReceiveTime = packet.ReceiveTime
-							tmpStr, err := json.Marshal(&cmdBuf)
-							if err != nil {
-								log.Warnf("%v", err)
-							} else {
-								_, err = cmdFile.Write(tmpStr)
-								err = cmdFile.WriteByte('\n')
-								if err != nil {
-									log.Warnf("wirte file fail:%v", err)
-								}
-							}
-						}
+		// 交给TCP重组，完整的命令在 redisStream 中解析
+		ci := captureContext(packet.ci)
+		assembler.AssembleWithContext(flow, tcp, &ci)
+	}
+}
 
-						// 统计访问总次数
-						stat.TotalAccessSum += 1
-						// 获取访问key
-						var key string
-						if len(fullCmd) >= 2 {
-							for i := 1; i < len(fullCmd); i++ {
-								if fullCmd[i] == " " || len(fullCmd[i]) <= 2 {
-									continue
-								}
-								key = fullCmd[i]
-								break
-							}
+// redisCommand 一条完整的请求命令
+type redisCommand struct {
+	args        []string
+	cmd         string     // 大写的命令名
+	key         string     // 访问的key
+	redisCmd    string     // 命令 + 截断后的key
+	clientIp    string     // 客户端地址
+	db          string     // 命令所在的 db，未知时为空
+	user        string     // 连接认证的用户，未知时为空
+	name        string     // 连接的客户端名称，未设置时为空
+	authUser    string     // AUTH 或 HELLO AUTH 认证的用户，响应成功后生效
+	selectDb    string     // SELECT 选择的 db，响应成功后生效
+	inMulti     bool       // MULTI 之后排队的命令
+	queued      int64      // EXEC 提交的命令数
+	script      string     // 脚本的 SHA1 或函数名
+	moreKeys    []string   // 第一个 key 之后的 key，例如 MGET、DEL 和脚本声明的 key
+	moreCmds    []string   // 命令 + 截断后的 moreKeys
+	blocking    bool       // 阻塞命令，耗时为等待时间，不计入服务端耗时
+	risky       *RiskyCall // 危险或 O(N) 命令，等待响应和耗时
+	receiveTime int64
+	size        int  // 请求编码后的字节数
+	ignore      bool // 认证命令不统计
+}
 
-						}
+func newRedisCommand(args []string, cmdLen int, receiveTime int64) *redisCommand {
+	c := &redisCommand{
+		args:        args,
+		cmd:         strings.ToUpper(args[0]),
+		receiveTime: receiveTime,
+	}
+	// 需要去除认证
+	if c.cmd == "AUTH" {
+		c.ignore = true
+		return c
+	}
+	// 按命令表获取访问的 key，第一个 key 作为命令的 key
+	flags := commandTable[c.cmd].flags
+	c.blocking = flags&flagBlocking != 0
+	switch {
+	case flags&flagScript != 0 && len(args) >= 3:
+		scriptCommand(c)
+	case c.cmd == "XREAD" || c.cmd == "XREADGROUP":
+		c.blocking = streamBlocking(args)
+	}
+	for i, index := range commandKeyIndexes(c.cmd, args) {
+		if i == 0 {
+			c.key = args[index]
+			continue
+		}
+		c.moreKeys = append(c.moreKeys, args[index])
+		c.moreCmds = append(c.moreCmds, c.cmd+" "+truncateKey(args[index], cmdLen))
+	}
+	c.redisCmd = c.cmd + " " + truncateKey(c.key, cmdLen)
+	return c
+}
 
-						l := len(key)
-						// 判断key长度
-						if l >= cmdLen {
-							l = cmdLen
-						}
-						// 收集key访问次数
-						redisCmd := cmd + " " + key[:l]
-						if _, ok := stat.tmpTopKeys[redisCmd]; ok {
-							stat.tmpTopKeys[redisCmd] += 1
-						} else {
-							stat.tmpTopKeys[redisCmd] = 1
-						}
-						/*if foundKv(stat.TopKeys, redisCmd) {
-							modifyKv(stat.TopKeys, redisCmd, 1)
-						} else {
-							stat.TopKeys = addKv(stat.TopKeys, redisCmd, 1)
-						}*/
-
-						// 收集访问key类型,范围次数
-						if foundKv(stat.TopCommands, cmd) {
-							modifyKv(stat.TopCommands, cmd, 1)
-						} else {
-							stat.TopCommands = addKv(stat.TopCommands, cmd, 1)
-						}
+// truncateKey 超过 cmdLen 的 key 截断
+func truncateKey(key string, cmdLen int) string {
+	if len(key) >= cmdLen {
+		return key[:cmdLen]
+	}
+	return key
+}
 
-						// 收集IP信息
-						if foundKv(stat.IPV4Call, Src) {
-							modifyKv(stat.IPV4Call, Src, 1)
-						} else {
-							stat.IPV4Call = addKv(stat.IPV4Call, Src, 1)
-						}
+// commandInfo 统计一条完整的命令
+func commandInfo(c *redisCommand, clientIp, family, src, dst string, stat *OverallStats, cmdFile *commandWriter) {
+	if cmdFile != nil {
+		cmdFile.write(c, src, dst)
+	}
 
-						// 记录接受信息
-						uniqueIdentification := fmt.Sprintf("%s:%s-%s:%s-%d", Src, tcp.SrcPort.String(), Dst, tcp.DstPort.String(), tcp.Ack)
-						log.Debugf("记录key：%s ", uniqueIdentification)
-						if _, ok := timeDiff[uniqueIdentification]; ok {
-							log.Debugf("key存在 %s", uniqueIdentification)
-						} else {
-							timeDiff[uniqueIdentification] = map[string]int64{
-								redisCmd: packet.ReceiveTime,
-							}
-						}
-						// 收集前缀key
-						prefixes := getPrefixes(key, separators)
-						for _, prefix := range prefixes {
-							if len(prefix) == 0 {
-								continue
-							}
-							if foundKv(stat.TopPrefixes, prefix) {
-								modifyKv(stat.TopPrefixes, prefix, 1)
-							} else {
-								stat.TopPrefixes = addKv(stat.TopPrefixes, prefix, 1)
-							}
-						}
-					}
-				}
+	// 统计访问总次数
+	stat.TotalAccessSum += 1
+	// 收集key访问次数
+	if c.key != "" {
+		if stat.topHitters != nil {
+			stat.topHitters.add(c.redisCmd, 1)
+		} else {
+			stat.tmpTopKeys[c.redisCmd] += 1
+		}
+	}
+	for i, key := range c.moreKeys {
+		if stat.topHitters != nil {
+			stat.topHitters.add(c.moreCmds[i], 1)
+		} else {
+			stat.tmpTopKeys[c.moreCmds[i]] += 1
+		}
+		for _, prefix := range getPrefixes(key, separators) {
+			if len(prefix) == 0 {
+				continue
 			}
+			prefixInfo(stat, prefix)
 		}
 	}
-	// 取10000条采样
-	/*sort.Slice(stat.TopPrefixes, func(i, j int) bool { return stat.TopPrefixes[i].Value > stat.TopPrefixes[j].Value })
-	sort.Slice(stat.TopKeys, func(i, j int) bool { return stat.TopKeys[i].Value > stat.TopKeys[j].Value })
-	sort.Slice(stat.TopCommands, func(i, j int) bool { return stat.TopCommands[i].Value > stat.TopCommands[j].Value })
-	sort.Slice(stat.HeaviestCommands, func(i, j int) bool {
-		return stat.HeaviestCommands[i].Value > stat.HeaviestCommands[j].Value
-	})
-	sort.Slice(stat.IPV4Call, func(i, j int) bool { return stat.IPV4Call[i].Value > stat.IPV4Call[j].Value })*/
-	sort.Slice(stat.SlowestCalls, func(i, j int) bool { return stat.SlowestCalls[i].Value < stat.SlowestCalls[j].Value })
-	if len(stat.SlowestCalls) >= 1000 {
-		stat.SlowestCalls = stat.SlowestCalls[1:]
+
+	// 收集访问key类型,范围次数
+	if foundKv(stat.TopCommands, c.cmd) {
+		modifyKv(stat.TopCommands, c.cmd, 1)
+	} else {
+		stat.TopCommands = addKv(stat.TopCommands, c.cmd, 1)
+	}
+
+	// 收集IP信息
+	if family == "ipv4" {
+		if foundKv(stat.IPV4Call, clientIp) {
+			modifyKv(stat.IPV4Call, clientIp, 1)
+		} else {
+			stat.IPV4Call = addKv(stat.IPV4Call, clientIp, 1)
+		}
+	}
+	if foundKv(stat.ClientCall, clientIp) {
+		modifyKv(stat.ClientCall, clientIp, 1)
+	} else {
+		stat.ClientCall = addKv(stat.ClientCall, clientIp, 1)
+	}
+	if foundKv(stat.ClientFamily, family) {
+		modifyKv(stat.ClientFamily, family, 1)
+	} else {
+		stat.ClientFamily = addKv(stat.ClientFamily, family, 1)
+	}
+	groupInfo(c, stat)
+	scriptInfo(c, stat)
+	publishInfo(c, stat)
+	riskyInfo(c, src, stat)
+
+	// 收集前缀key
+	prefixes := getPrefixes(c.key, separators)
+	for _, prefix := range prefixes {
+		if len(prefix) == 0 {
+			continue
+		}
+		prefixInfo(stat, prefix)
+	}
+	windowInfo(c, prefixes, stat)
+	classInfo(c, prefixes, stat)
+	slotInfo(c, stat)
+	requestBytesInfo(c, stat)
+}
+
+// prefixInfo 统计前缀访问次数，近似统计时使用 prefixHitters
+func prefixInfo(stat *OverallStats, prefix string) {
+	if stat.prefixHitters != nil {
+		stat.prefixHitters.add(prefix, 1)
+		return
+	}
+	if foundKv(stat.TopPrefixes, prefix) {
+		modifyKv(stat.TopPrefixes, prefix, 1)
+	} else {
+		stat.TopPrefixes = addKv(stat.TopPrefixes, prefix, 1)
 	}
 }
 
-func aggregation(stat *OverallStats, newStat map[int]*link) {
+// latencyInfo 统计一条命令从请求到响应的耗时，单位微秒
+func latencyInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	riskyLatencyInfo(c, execTime, stat)
+	// 阻塞命令按设计等待数据，单独统计等待时间
+	if c.blocking {
+		blockingLatencyInfo(c, execTime, stat)
+		return
+	}
+	stat.TotalAccessTime += execTime
+	latencyHistogramInfo(c, execTime, stat)
+	groupLatencyInfo(c, execTime, stat)
+	execLatencyInfo(c, execTime, stat)
+	scriptLatencyInfo(c, execTime, stat)
+	if foundKv(stat.HeaviestCommands, c.cmd) {
+		modifyKv(stat.HeaviestCommands, c.cmd, execTime)
+	} else {
+		stat.HeaviestCommands = addKv(stat.HeaviestCommands, c.cmd, execTime)
+	}
+	// 只保留最慢的1000条，满了之后由小到大排序，替换最快的一条
+	if len(stat.SlowestCalls) < 1000 {
+		stat.SlowestCalls = addKv(stat.SlowestCalls, c.redisCmd, execTime)
+		if len(stat.SlowestCalls) == 1000 {
+			sort.Slice(stat.SlowestCalls, func(i, j int) bool { return stat.SlowestCalls[i].Value < stat.SlowestCalls[j].Value })
+		}
+	} else if execTime > stat.SlowestCalls[0].Value {
+		stat.SlowestCalls[0] = &KV{
+			Key:   c.redisCmd,
+			Value: execTime,
+		}
+		sort.Slice(stat.SlowestCalls, func(i, j int) bool { return stat.SlowestCalls[i].Value < stat.SlowestCalls[j].Value })
+	}
+}
+
+// aggregation 合并多个线程的统计，只复制数据，合并后 newStat 仍可以单独分析
+func aggregation(stat *OverallStats, newStat []*OverallStats) {
 	for i, l := range newStat {
 		log.Infof("第%d个统计周期", i)
-		stat.TotalAccessSum += l.stat.TotalAccessSum
-		stat.TotalAccessTime += l.stat.TotalAccessTime
-		stat.ActiveProcessed += l.stat.ActiveProcessed
-		stat.DiscardPacketSum += l.stat.DiscardPacketSum
-		for _, value := range l.stat.IPV4Call {
+		stat.TotalAccessSum += l.TotalAccessSum
+		stat.TotalAccessTime += l.TotalAccessTime
+		stat.ActiveProcessed += l.ActiveProcessed
+		stat.DiscardPacketSum += l.DiscardPacketSum
+		stat.UnmatchedReplySum += l.UnmatchedReplySum
+		aggregationReply(stat, l)
+		aggregationWindow(stat, l)
+		aggregationHitters(stat, l)
+		aggregationLatency(stat, l)
+		aggregationPayload(stat, l)
+		aggregationCluster(stat, l)
+		aggregationConnection(stat, l)
+		aggregationGroup(stat, l)
+		aggregationTransaction(stat, l)
+		aggregationScript(stat, l)
+		aggregationPubSub(stat, l)
+		aggregationBlocking(stat, l)
+		aggregationClass(stat, l)
+		aggregationRisky(stat, l)
+		aggregationNetwork(stat, l)
+		for _, value := range l.IPV4Call {
 			if foundKv(stat.IPV4Call, value.Key) {
 				modifyKv(stat.IPV4Call, value.Key, value.Value)
 			} else {
-				stat.IPV4Call = append(stat.IPV4Call, value)
+				stat.IPV4Call = addKv(stat.IPV4Call, value.Key, value.Value)
+			}
+		}
+		for _, value := range l.ClientCall {
+			if foundKv(stat.ClientCall, value.Key) {
+				modifyKv(stat.ClientCall, value.Key, value.Value)
+			} else {
+				stat.ClientCall = addKv(stat.ClientCall, value.Key, value.Value)
 			}
 		}
-		log.Infof("number: %d IPV4Call", i)
-		for _, value := range l.stat.TopPrefixes {
+		for _, value := range l.ClientFamily {
+			if foundKv(stat.ClientFamily, value.Key) {
+				modifyKv(stat.ClientFamily, value.Key, value.Value)
+			} else {
+				stat.ClientFamily = addKv(stat.ClientFamily, value.Key, value.Value)
+			}
+		}
+		log.Infof("number: %d ClientCall", i)
+		for _, value := range l.TopPrefixes {
 			if foundKv(stat.TopPrefixes, value.Key) {
 				modifyKv(stat.TopPrefixes, value.Key, value.Value)
 			} else {
-				stat.TopPrefixes = append(stat.TopPrefixes, value)
+				stat.TopPrefixes = addKv(stat.TopPrefixes, value.Key, value.Value)
 			}
 		}
 		log.Infof("number: %d TopCommands", i)
-		for _, value := range l.stat.TopCommands {
+		for _, value := range l.TopCommands {
 			if foundKv(stat.TopCommands, value.Key) {
 				modifyKv(stat.TopCommands, value.Key, value.Value)
 			} else {
-				stat.TopCommands = append(stat.TopCommands, value)
+				stat.TopCommands = addKv(stat.TopCommands, value.Key, value.Value)
 			}
 		}
 		log.Infof("number: %d TopKeys", i)
@@ -553,47 +1019,87 @@ func aggregation(stat *OverallStats, newStat map[int]*link) {
 				stat.TopKeys = append(stat.TopKeys, value)
 			}
 		}*/
-		for key, value := range l.stat.tmpTopKeys {
+		for key, value := range l.tmpTopKeys {
 			if _, ok := stat.tmpTopKeys[key]; ok {
 				stat.tmpTopKeys[key] += value
 			} else {
 				stat.tmpTopKeys[key] = value
 			}
 		}
-		l.stat.tmpTopKeys = nil
 		log.Infof("number: %d SlowestCalls", i)
-		for _, value := range l.stat.SlowestCalls {
+		for _, value := range l.SlowestCalls {
 			stat.SlowestCalls = append(stat.SlowestCalls, value)
 		}
 		log.Infof("number: %d HeaviestCommands", i)
-		for _, value := range l.stat.HeaviestCommands {
+		for _, value := range l.HeaviestCommands {
 			if foundKv(stat.HeaviestCommands, value.Key) {
 				modifyKv(stat.HeaviestCommands, value.Key, value.Value)
 			} else {
-				stat.HeaviestCommands = append(stat.HeaviestCommands, value)
+				stat.HeaviestCommands = addKv(stat.HeaviestCommands, value.Key, value.Value)
 			}
 		}
-		stat.CloseConnectNum += l.stat.CloseConnectNum
-		stat.NewConnectNum += l.stat.NewConnectNum
-		stat.PacketSum += l.stat.PacketSum
-		stat.CloseConnectNum += l.stat.CloseConnectNum
+		stat.CloseConnectNum += l.CloseConnectNum
+		stat.NewConnectNum += l.NewConnectNum
+		stat.PacketSum += l.PacketSum
 	}
 }
 
 func newOverallStats() *OverallStats {
 	return &OverallStats{
-		TopPrefixes:      []*KV{},
-		TopKeys:          []*KV{},
-		TopCommands:      []*KV{},
-		HeaviestCommands: []*KV{},
-		SlowestCalls:     []*KV{},
-		IPV4Call:         []*KV{},
-		tmpTopKeys:       map[string]int64{},
+		TopPrefixes:         []*KV{},
+		TopKeys:             []*KV{},
+		TopCommands:         []*KV{},
+		HeaviestCommands:    []*KV{},
+		SlowestCalls:        []*KV{},
+		IPV4Call:            []*KV{},
+		ClientCall:          []*KV{},
+		ClientFamily:        []*KV{},
+		UserCall:            []*KV{},
+		ErrorCommands:       []*KV{},
+		MissPrefixes:        []*HitRatio{},
+		TopReplyKeys:        []*KV{},
+		TopTransferKeys:     []*KV{},
+		TopAvgReplyKeys:     []*KV{},
+		CommandRequestBytes: []*KV{},
+		CommandReplyBytes:   []*KV{},
+		HotSlots:            []*SlotStats{},
+		ClientRedirects:     []*RedirectRatio{},
+		Windows:             []*WindowStats{},
+		CommandLatency:      []*LatencyStats{},
+		PrefixLatency:       []*LatencyStats{},
+		tmpTopKeys:          map[string]int64{},
+		tmpMissPrefixes:     map[string]*HitRatio{},
+		tmpReplyBytes:       map[string]int64{},
+		tmpReplyCount:       map[string]int64{},
+		tmpTransferBytes:    map[string]int64{},
+		tmpSlots:            map[int]*SlotStats{},
+		tmpClientRedirects:  map[string]*RedirectRatio{},
+		latency:             newLatencyHistogram(),
+		tmpCommandLatency:   map[string]*latencyHistogram{},
+		tmpPrefixLatency:    map[string]*latencyHistogram{},
+		latencyHitters:      newSpaceSaving(maxLatencyPrefix),
+		conns:               map[string]*connState{},
+		idleSessions:        map[string]connSession{},
+		tmpChurn:            map[string]*ChurnStats{},
+		tmpDbStats:          map[string]*GroupStats{},
+		tmpNameStats:        map[string]*GroupStats{},
+		dbHitters:           newSpaceSaving(maxGroups),
+		nameHitters:         newSpaceSaving(maxGroups),
+		tmpPipelineDepth:    map[string]int64{},
+		tmpScripts:          map[string]*ScriptStats{},
+		scriptHitters:       newSpaceSaving(maxScripts),
+		tmpChannels:         map[string]*ChannelStats{},
+		channelHitters:      newSpaceSaving(maxChannels),
+		tmpPatterns:         map[string]*ChannelStats{},
+		patternHitters:      newSpaceSaving(maxChannels),
+		tmpBlocking:         map[string]*BlockingStats{},
+		blockingHitters:     newSpaceSaving(maxBlockingKeys),
+		tmpBlockingLatency:  map[string]*latencyHistogram{},
+		tmpClasses:          map[string]int64{},
+		tmpPrefixClasses:    map[string]*PrefixClassStats{},
+		classHitters:        newSpaceSaving(maxClassPrefixes),
+		tmpRiskyReasons:     map[string]int64{},
+		tmpClientNetwork:    map[string]*NetworkStats{},
+		tmpServerNetwork:    map[string]*NetworkStats{},
 	}
 }
-
-func hashToBucket(s string, threadNumber uint32) int {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return int(h.Sum32() % threadNumber)
-}
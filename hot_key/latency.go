@@ -0,0 +1,189 @@
+package hotkeys
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	latencySubBits    = 6                   // 每个 2 的幂区间分 64 个桶，相对误差不超过 1/64
+	latencySubBuckets = 1 << latencySubBits // 小于该值的耗时精确记录
+)
+
+var maxLatencyPrefix = 1000 // 单线程最多记录耗时分布的前缀数量，按请求数保留最多的
+
+// LatencyStats 一类请求的耗时分布，单位微秒
+type LatencyStats struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"` // 匹配到响应的请求数
+	P50   int64  `json:"p_50"`
+	P90   int64  `json:"p_90"`
+	P99   int64  `json:"p_99"`
+	P999  int64  `json:"p_999"`
+	Max   int64  `json:"max"`
+}
+
+// latencyHistogram 对数分桶的耗时直方图，可以在线程间合并
+type latencyHistogram struct {
+	counts []int64
+	total  int64
+	max    int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{}
+}
+
+// latencyBucket 耗时所在的桶
+func latencyBucket(v int64) int {
+	if v < latencySubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - latencySubBits - 1
+	return latencySubBuckets + shift*latencySubBuckets + int(v>>shift) - latencySubBuckets
+}
+
+// latencyBucketMax 桶内最大的耗时
+func latencyBucketMax(idx int) int64 {
+	if idx < latencySubBuckets {
+		return int64(idx)
+	}
+	shift := (idx - latencySubBuckets) / latencySubBuckets
+	m := int64((idx-latencySubBuckets)%latencySubBuckets + latencySubBuckets)
+	return (m+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	idx := latencyBucket(v)
+	if idx >= len(h.counts) {
+		counts := make([]int64, idx+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[idx]++
+	h.total++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *latencyHistogram) merge(o *latencyHistogram) {
+	if len(o.counts) > len(h.counts) {
+		counts := make([]int64, len(o.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// percentile 分位值，取桶内最大值且不超过记录到的最大值
+func (h *latencyHistogram) percentile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var sum int64
+	for i, n := range h.counts {
+		sum += n
+		if sum >= rank {
+			if v := latencyBucketMax(i); v < h.max {
+				return v
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+func (h *latencyHistogram) summary(key string) *LatencyStats {
+	return &LatencyStats{
+		Key:   key,
+		Count: h.total,
+		P50:   h.percentile(0.5),
+		P90:   h.percentile(0.9),
+		P99:   h.percentile(0.99),
+		P999:  h.percentile(0.999),
+		Max:   h.max,
+	}
+}
+
+// latencyHistogramInfo 记录每个匹配到响应的请求耗时
+func latencyHistogramInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	stat.latency.record(execTime)
+	h, ok := stat.tmpCommandLatency[c.cmd]
+	if !ok {
+		h = newLatencyHistogram()
+		stat.tmpCommandLatency[c.cmd] = h
+	}
+	h.record(execTime)
+	for _, prefix := range getPrefixes(c.key, separators) {
+		if len(prefix) == 0 {
+			continue
+		}
+		// 前缀过多时替换请求数最少的前缀
+		if evicted, full := stat.latencyHitters.admit(prefix, 1); full {
+			delete(stat.tmpPrefixLatency, evicted)
+		}
+		h, ok = stat.tmpPrefixLatency[prefix]
+		if !ok {
+			h = newLatencyHistogram()
+			stat.tmpPrefixLatency[prefix] = h
+		}
+		h.record(execTime)
+	}
+}
+
+func aggregationLatency(stat *OverallStats, newStat *OverallStats) {
+	stat.latency.merge(newStat.latency)
+	for key, value := range newStat.tmpCommandLatency {
+		h, ok := stat.tmpCommandLatency[key]
+		if !ok {
+			h = newLatencyHistogram()
+			stat.tmpCommandLatency[key] = h
+		}
+		h.merge(value)
+	}
+	for key, value := range newStat.tmpPrefixLatency {
+		h, ok := stat.tmpPrefixLatency[key]
+		if !ok {
+			h = newLatencyHistogram()
+			stat.tmpPrefixLatency[key] = h
+		}
+		h.merge(value)
+	}
+}
+
+// analysisLatency 计算整体、每个命令和 top 前缀的分位值，需要在 TopPrefixes 排序截断之后调用
+func analysisLatency(stat *OverallStats) {
+	stat.Median = stat.latency.percentile(0.5)
+	stat.P999 = stat.latency.percentile(0.999)
+	stat.P99 = stat.latency.percentile(0.99)
+	stat.P95 = stat.latency.percentile(0.95)
+	stat.P90 = stat.latency.percentile(0.9)
+	stat.P85 = stat.latency.percentile(0.85)
+	stat.P80 = stat.latency.percentile(0.8)
+	stat.P75 = stat.latency.percentile(0.75)
+	stat.Max = stat.latency.max
+	for key, h := range stat.tmpCommandLatency {
+		stat.CommandLatency = append(stat.CommandLatency, h.summary(key))
+	}
+	sort.Slice(stat.CommandLatency, func(i, j int) bool { return stat.CommandLatency[i].Count > stat.CommandLatency[j].Count })
+	for _, prefix := range stat.TopPrefixes {
+		if h, ok := stat.tmpPrefixLatency[prefix.Key]; ok {
+			stat.PrefixLatency = append(stat.PrefixLatency, h.summary(prefix.Key))
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package hotkeys
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// monitorLine MONITOR 输出的一条命令
+type monitorLine struct {
+	receiveTime int64
+	db          string
+	addr        string // 客户端地址，lua 脚本中执行时为 lua，unix socket 为 unix:path
+	args        []string
+}
+
+// ShowMonitor 分析 redis MONITOR 输出，例如 redis-cli monitor 的结果，读取到文件结束
+// MONITOR 中没有响应，耗时、错误和响应大小相关的统计为空
+func ShowMonitor(ctx context.Context, opts *HotKeyOptions) (map[string]interface{}, error) {
+	if opts.MonitorFile == nil {
+		return nil, fmt.Errorf("no monitor file")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Separators != "" {
+		defer setSeparators(opts.Separators)()
+	}
+	var capacity int
+	if opts.KeyMemory > 0 {
+		capacity = hitterCapacity(opts.KeyMemory, 1, opts.CmdLen)
+	}
+	stat := newSessionStats(opts, capacity)
+	stat.LatencyUnavailable = true
+	err := readLines(ctx, opts.MonitorFile.Name(), opts.MonitorFile, func(line []byte) bool {
+		return monitorLineInfo(string(line), opts, stat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return analysisSource(stat, opts.Top), nil
+}
+
+// monitorLineInfo 统计一行 MONITOR 输出，格式错误时返回 false
+func monitorLineInfo(line string, opts *HotKeyOptions, stat *OverallStats) bool {
+	line = strings.TrimRight(line, "\r\n")
+	// redis-cli monitor 第一行输出 OK
+	if line == "" || line == "OK" {
+		return true
+	}
+	m, err := parseMonitorLine(line)
+	if err != nil {
+		return false
+	}
+	if opts.StartTime > 0 && m.receiveTime < opts.StartTime {
+		return true
+	}
+	if opts.EndTime > 0 && m.receiveTime >= opts.EndTime {
+		return true
+	}
+	c := newRedisCommand(m.args, opts.CmdLen, m.receiveTime)
+	if c.ignore {
+		return true
+	}
+	c.db = m.db
+	clientIp, family := monitorClient(m.addr)
+	sourceCommand(c, clientIp, family, m.addr, "", stat)
+	return true
+}
+
+// monitorClient 客户端地址和地址类型，lua 和 unix socket 单独统计
+func monitorClient(addr string) (string, string) {
+	if addr == "lua" {
+		return addr, "lua"
+	}
+	if strings.HasPrefix(addr, "unix:") {
+		return addr, "unix"
+	}
+	return commandLogClient(addr)
+}
+
+// parseMonitorLine 解析一行 MONITOR 输出，例如
+// 1700000000.123456 [0 10.0.0.1:5555] "GET" "k"
+func parseMonitorLine(line string) (*monitorLine, error) {
+	ts, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf("monitor line without client: %q", line)
+	}
+	receiveTime, err := parseMonitorTime(ts)
+	if err != nil {
+		return nil, err
+	}
+	// IPv6 地址为 [::1]:5555，以 "] " 结束客户端信息
+	end := strings.Index(rest, "] ")
+	if !strings.HasPrefix(rest, "[") || end < 0 {
+		return nil, fmt.Errorf("monitor line without client: %q", line)
+	}
+	db, addr, _ := strings.Cut(rest[1:end], " ")
+	args, err := parseMonitorArgs(rest[end+2:])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("monitor line without command: %q", line)
+	}
+	return &monitorLine{receiveTime: receiveTime, db: db, addr: addr, args: args}, nil
+}
+
+// parseMonitorTime 秒级时间戳，小数部分为微秒
+func parseMonitorTime(ts string) (int64, error) {
+	sec, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(frac) > 6 {
+		frac = frac[:6]
+	}
+	var us int64
+	if frac != "" {
+		us, err = strconv.ParseInt(frac+strings.Repeat("0", 6-len(frac)), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s*1000*1000 + us, nil
+}
+
+// parseMonitorArgs 解析双引号包围的参数，转义规则与 redis sdscatrepr 一致
+func parseMonitorArgs(s string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) {
+			return args, nil
+		}
+		if s[i] != '"' {
+			return nil, fmt.Errorf("monitor argument not quoted at %d", i)
+		}
+		i++
+		var b strings.Builder
+		for {
+			if i >= len(s) {
+				return nil, fmt.Errorf("monitor argument not terminated")
+			}
+			ch := s[i]
+			if ch == '"' {
+				i++
+				break
+			}
+			if ch != '\\' || i+1 >= len(s) {
+				b.WriteByte(ch)
+				i++
+				continue
+			}
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'x':
+				if i+3 < len(s) {
+					if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+						b.WriteByte(byte(v))
+						i += 4
+						continue
+					}
+				}
+				b.WriteByte('x')
+			default:
+				b.WriteByte(s[i+1])
+			}
+			i += 2
+		}
+		args = append(args, b.String())
+	}
+}
@@ -0,0 +1,65 @@
+package hotkeys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMonitorLine(t *testing.T) {
+	m, err := parseMonitorLine(`1700000000.1234 [2 [::1]:5555] "SET" "a\"b" "x\x00\\y\n"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.receiveTime != 1700000000123400 || m.db != "2" || m.addr != "[::1]:5555" {
+		t.Fatalf("got %+v", m)
+	}
+	if len(m.args) != 3 || m.args[1] != `a"b` || m.args[2] != "x\x00\\y\n" {
+		t.Fatalf("got args %q", m.args)
+	}
+	for _, line := range []string{`1700000000.1 [0 lua] "GET`, `1700000000.1 GET k`, `abc [0 lua] "GET"`} {
+		if _, err = parseMonitorLine(line); err == nil {
+			t.Fatalf("%s: want error", line)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "monitor.txt")
+	content := "OK\n" +
+		"1700000000.000001 [0 10.0.0.1:5555] \"GET\" \"user:1001\"\n" +
+		"1700000000.500000 [0 10.0.0.1:5555] \"AUTH\" \"secret\"\n" +
+		"1700000001.000001 [1 unix:/tmp/redis.sock] \"HGET\" \"user:1001\" \"name\"\n" +
+		"1700000002.000001 [1 lua] \"GET\" \"user:1002\"\n" +
+		"broken line\n"
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ShowMonitor(nil, &HotKeyOptions{MonitorFile: f, CmdLen: 100, Top: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := data["total_sum"].(int64); total != 3 {
+		t.Fatalf("got total %d, want 3", total)
+	}
+	if !data["latency_unavailable"].(bool) {
+		t.Fatal("latency should be unavailable")
+	}
+	db := data["db_stats"].([]*GroupStats)
+	if len(db) != 2 || db[0].Key != "1" || db[0].Calls != 2 || len(db[0].TopKeys) != 2 {
+		t.Fatalf("got db stats %+v", db)
+	}
+	family := data["client_family"].([]*KV)
+	if len(family) != 3 {
+		t.Fatalf("got client family %+v", family)
+	}
+	if start, end := data["monitor_start_time"].(int64), data["monitor_end_time"].(int64); end-start != 2*time.Second.Microseconds() {
+		t.Fatalf("got monitor time %d-%d", start, end)
+	}
+}
@@ -0,0 +1,258 @@
+package hotkeys
+
+import (
+	"github.com/google/gopacket/layers"
+	"sort"
+)
+
+const maxNetworkKeys = 10000 // 单线程最多记录网络统计的客户端和 redis 地址数量
+
+// NetworkStats 一个客户端 IP 或 redis 地址上连接的 TCP 网络质量
+type NetworkStats struct {
+	Key               string  `json:"key"`
+	Segments          int64   `json:"segments"`            // 带负载的 TCP 段数
+	Retransmissions   int64   `json:"retransmissions"`     // 序号已经出现过的重传段数
+	OutOfOrder        int64   `json:"out_of_order"`        // 序号跳过了未到达数据的乱序段数
+	RetransmitRatio   float64 `json:"retransmit_ratio"`    // 重传段占带负载段的比例
+	ClientZeroWindows int64   `json:"client_zero_windows"` // 客户端窗口变为零的次数，客户端读取响应过慢
+	ServerZeroWindows int64   `json:"server_zero_windows"` // redis 窗口变为零的次数
+	ClientResets      int64   `json:"client_resets"`       // 客户端发送 RST 的次数
+	ServerResets      int64   `json:"server_resets"`       // redis 发送 RST 的次数
+	Handshakes        int64   `json:"handshakes"`          // 抓到完整三次握手的连接数
+	ServerRTTTime     int64   `json:"server_rtt_time"`     // SYN 到 SYN-ACK 的总耗时，微秒
+	AvgServerRTT      int64   `json:"avg_server_rtt"`      // 平均 SYN 到 SYN-ACK 的耗时，抓包点到 redis 的往返时间，微秒
+	MaxServerRTT      int64   `json:"max_server_rtt"`      // 最大 SYN 到 SYN-ACK 的耗时，微秒
+	ClientRTTTime     int64   `json:"client_rtt_time"`     // SYN-ACK 到 ACK 的总耗时，微秒
+	AvgClientRTT      int64   `json:"avg_client_rtt"`      // 平均 SYN-ACK 到 ACK 的耗时，抓包点到客户端的往返时间，微秒
+	MaxClientRTT      int64   `json:"max_client_rtt"`      // 最大 SYN-ACK 到 ACK 的耗时，微秒
+}
+
+// tcpSeqState 连接一个方向上收到的序号范围，只跟踪一个空洞
+// next 之前的数据连续收到，gap 到 high 之间的数据在空洞之后提前收到
+type tcpSeqState struct {
+	next uint32 // 连续收到的数据的结束序号
+	gap  uint32 // 空洞之后提前到达的数据的起始序号，没有空洞时等于 next
+	high uint32 // 收到的最大结束序号
+	init bool
+}
+
+func (s *tcpSeqState) start(end uint32) {
+	s.next, s.gap, s.high, s.init = end, end, end, true
+}
+
+// add 记录一个带负载的段，只有整段或部分数据已经收到过时才是重传，填补空洞的段不算重传
+func (s *tcpSeqState) add(seq, end uint32) (retransmit, outOfOrder bool) {
+	hole := seqBefore(s.next, s.high)
+	switch {
+	case !seqBefore(s.next, end):
+		// keepalive 探测包的序号为期望序号减一，只带 0 或 1 个字节
+		return !(end-seq <= 1 && seq == s.next-1), false
+	case !seqBefore(s.next, seq):
+		retransmit = seq != s.next
+		s.next = end
+	case !hole:
+		outOfOrder = true
+		s.gap, s.high = seq, end
+		return
+	case seqBefore(s.high, seq):
+		// 又出现一个空洞，只跟踪第一个，之后的数据按连续处理
+		outOfOrder = true
+		s.high = end
+		return
+	case !seqBefore(seq, s.gap):
+		// 空洞之后的数据
+		retransmit = seqBefore(seq, s.high)
+		if seqBefore(s.high, end) {
+			s.high = end
+		}
+		return
+	default:
+		// 填补了空洞的后半部分
+		s.gap = seq
+		if seqBefore(s.high, end) {
+			s.high = end
+		}
+		return
+	}
+	if seqBefore(s.high, s.next) {
+		s.high = s.next
+	}
+	if hole && !seqBefore(s.next, s.gap) {
+		// 空洞已填满，连续到提前收到的数据末尾
+		if seqBefore(s.next, s.high) {
+			s.next = s.high
+		}
+	}
+	if !seqBefore(s.next, s.high) {
+		s.gap = s.next
+	}
+	return
+}
+
+// seqBefore 按 TCP 序号回绕比较 a 是否在 b 之前
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func networkStats(m map[string]*NetworkStats, key string) *NetworkStats {
+	s, ok := m[key]
+	if !ok {
+		if len(m) >= maxNetworkKeys {
+			return nil
+		}
+		s = &NetworkStats{Key: key}
+		m[key] = s
+	}
+	return s
+}
+
+// networkInfo 按数据包统计重传、乱序、零窗口、RST 和握手耗时，server 为 redis 的 ip:port
+func networkInfo(stat *OverallStats, c *connState, tcp *layers.TCP, server string, toServer bool, receiveTime int64) {
+	var event NetworkStats
+	dir := 0
+	if !toServer {
+		dir = 1
+	}
+	payload := uint32(len(tcp.Payload))
+	end := tcp.Seq + payload
+	if tcp.SYN || tcp.FIN {
+		end++
+	}
+	seq := &c.seq[dir]
+	switch {
+	case !seq.init || tcp.SYN:
+		// 中途抓到的连接以第一个包为准，重传的 SYN 不计入
+		if seq.init && tcp.SYN && tcp.Seq == seq.next-1 {
+			event.Retransmissions++
+		}
+		seq.start(end)
+	case payload == 0:
+	default:
+		retransmit, outOfOrder := seq.add(tcp.Seq, end)
+		if retransmit {
+			event.Retransmissions++
+		}
+		if outOfOrder {
+			event.OutOfOrder++
+		}
+	}
+	if payload > 0 {
+		event.Segments++
+	}
+	// 只统计窗口变为零，零窗口期间重复的 ACK 和探测包不计入
+	if !tcp.SYN && !tcp.RST && !tcp.FIN {
+		zero := tcp.Window == 0
+		if zero && !c.zeroWindow[dir] {
+			if toServer {
+				event.ClientZeroWindows++
+			} else {
+				event.ServerZeroWindows++
+			}
+		}
+		c.zeroWindow[dir] = zero
+	}
+	if tcp.RST {
+		if toServer {
+			event.ClientResets++
+		} else {
+			event.ServerResets++
+		}
+	}
+	// 三次握手：客户端 SYN、redis SYN-ACK、客户端 ACK
+	switch {
+	case tcp.SYN && !tcp.ACK && toServer:
+		if c.synTime == 0 {
+			c.synTime = receiveTime
+		}
+	case tcp.SYN && tcp.ACK && !toServer:
+		if c.synTime > 0 && c.synAckTime == 0 {
+			c.synAckTime = receiveTime
+		}
+	case tcp.ACK && toServer && c.synAckTime > 0 && !c.handshaked:
+		c.handshaked = true
+		serverRTT, clientRTT := c.synAckTime-c.synTime, receiveTime-c.synAckTime
+		event.Handshakes++
+		event.ServerRTTTime, event.MaxServerRTT = serverRTT, serverRTT
+		event.ClientRTTTime, event.MaxClientRTT = clientRTT, clientRTT
+	}
+	mergeNetwork(&stat.Network, &event)
+	if s := networkStats(stat.tmpClientNetwork, c.clientIp); s != nil {
+		mergeNetwork(s, &event)
+	}
+	if s := networkStats(stat.tmpServerNetwork, server); s != nil {
+		mergeNetwork(s, &event)
+	}
+}
+
+func mergeNetwork(s, n *NetworkStats) {
+	s.Segments += n.Segments
+	s.Retransmissions += n.Retransmissions
+	s.OutOfOrder += n.OutOfOrder
+	s.ClientZeroWindows += n.ClientZeroWindows
+	s.ServerZeroWindows += n.ServerZeroWindows
+	s.ClientResets += n.ClientResets
+	s.ServerResets += n.ServerResets
+	s.Handshakes += n.Handshakes
+	s.ServerRTTTime += n.ServerRTTTime
+	s.ClientRTTTime += n.ClientRTTTime
+	if n.MaxServerRTT > s.MaxServerRTT {
+		s.MaxServerRTT = n.MaxServerRTT
+	}
+	if n.MaxClientRTT > s.MaxClientRTT {
+		s.MaxClientRTT = n.MaxClientRTT
+	}
+}
+
+func aggregationNetwork(stat *OverallStats, newStat *OverallStats) {
+	mergeNetwork(&stat.Network, &newStat.Network)
+	for key, value := range newStat.tmpClientNetwork {
+		if s := networkStats(stat.tmpClientNetwork, key); s != nil {
+			mergeNetwork(s, value)
+		}
+	}
+	for key, value := range newStat.tmpServerNetwork {
+		if s := networkStats(stat.tmpServerNetwork, key); s != nil {
+			mergeNetwork(s, value)
+		}
+	}
+}
+
+func (s *NetworkStats) analysis() {
+	if s.Segments > 0 {
+		s.RetransmitRatio = Decimal(float64(s.Retransmissions) / float64(s.Segments))
+	}
+	if s.Handshakes > 0 {
+		s.AvgServerRTT = s.ServerRTTTime / s.Handshakes
+		s.AvgClientRTT = s.ClientRTTTime / s.Handshakes
+	}
+}
+
+// problems 网络异常事件数，用于排序
+func (s *NetworkStats) problems() int64 {
+	return s.Retransmissions + s.OutOfOrder + s.ClientZeroWindows + s.ServerZeroWindows + s.ClientResets + s.ServerResets
+}
+
+// analysisNetworks 按异常事件数排序，相同时按最大 SYN 到 SYN-ACK 耗时排序
+func analysisNetworks(m map[string]*NetworkStats, topNum int) []*NetworkStats {
+	res := make([]*NetworkStats, 0, len(m))
+	for _, s := range m {
+		s.analysis()
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].problems() != res[j].problems() {
+			return res[i].problems() > res[j].problems()
+		}
+		return res[i].MaxServerRTT > res[j].MaxServerRTT
+	})
+	if len(res) > topNum {
+		res = res[:topNum]
+	}
+	return res
+}
+
+func analysisNetwork(stat *OverallStats, topNum int) {
+	stat.Network.analysis()
+	stat.ClientNetwork = analysisNetworks(stat.tmpClientNetwork, topNum)
+	stat.ServerNetwork = analysisNetworks(stat.tmpServerNetwork, topNum)
+}
@@ -0,0 +1,89 @@
+package hotkeys
+
+import (
+	"sort"
+)
+
+// transferInfo 按 key 统计请求加响应的字节数
+func transferInfo(c *redisCommand, size int64, stat *OverallStats) {
+	if stat.transferHitters != nil {
+		stat.transferHitters.add(c.redisCmd, size)
+	} else {
+		stat.tmpTransferBytes[c.redisCmd] += size
+	}
+}
+
+// requestBytesInfo 统计请求编码后的字节数
+func requestBytesInfo(c *redisCommand, stat *OverallStats) {
+	size := int64(c.size)
+	stat.TotalRequestBytes += size
+	if foundKv(stat.CommandRequestBytes, c.cmd) {
+		modifyKv(stat.CommandRequestBytes, c.cmd, size)
+	} else {
+		stat.CommandRequestBytes = addKv(stat.CommandRequestBytes, c.cmd, size)
+	}
+	if c.key != "" {
+		transferInfo(c, size, stat)
+	}
+}
+
+// replyBytesInfo 统计响应编码后的字节数
+func replyBytesInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	size := int64(reply.size)
+	stat.TotalReplyBytes += size
+	if foundKv(stat.CommandReplyBytes, c.cmd) {
+		modifyKv(stat.CommandReplyBytes, c.cmd, size)
+	} else {
+		stat.CommandReplyBytes = addKv(stat.CommandReplyBytes, c.cmd, size)
+	}
+	if c.key == "" {
+		return
+	}
+	if stat.replyHitters != nil {
+		stat.replyHitters.add(c.redisCmd, size)
+	} else {
+		stat.tmpReplyBytes[c.redisCmd] += size
+		stat.tmpReplyCount[c.redisCmd]++
+	}
+	transferInfo(c, size, stat)
+}
+
+func aggregationPayload(stat *OverallStats, newStat *OverallStats) {
+	stat.TotalRequestBytes += newStat.TotalRequestBytes
+	stat.TotalReplyBytes += newStat.TotalReplyBytes
+	for _, value := range newStat.CommandRequestBytes {
+		if foundKv(stat.CommandRequestBytes, value.Key) {
+			modifyKv(stat.CommandRequestBytes, value.Key, value.Value)
+		} else {
+			stat.CommandRequestBytes = addKv(stat.CommandRequestBytes, value.Key, value.Value)
+		}
+	}
+	for _, value := range newStat.CommandReplyBytes {
+		if foundKv(stat.CommandReplyBytes, value.Key) {
+			modifyKv(stat.CommandReplyBytes, value.Key, value.Value)
+		} else {
+			stat.CommandReplyBytes = addKv(stat.CommandReplyBytes, value.Key, value.Value)
+		}
+	}
+	for key, value := range newStat.tmpReplyBytes {
+		stat.tmpReplyBytes[key] += value
+	}
+	for key, value := range newStat.tmpReplyCount {
+		stat.tmpReplyCount[key] += value
+	}
+	for key, value := range newStat.tmpTransferBytes {
+		stat.tmpTransferBytes[key] += value
+	}
+}
+
+func analysisPayload(stat *OverallStats, topNum int) {
+	sort.Slice(stat.CommandRequestBytes, func(i, j int) bool { return stat.CommandRequestBytes[i].Value > stat.CommandRequestBytes[j].Value })
+	sort.Slice(stat.CommandReplyBytes, func(i, j int) bool { return stat.CommandReplyBytes[i].Value > stat.CommandReplyBytes[j].Value })
+	stat.TopReplyKeys = topKv(stat.tmpReplyBytes, topNum)
+	stat.TopTransferKeys = topKv(stat.tmpTransferBytes, topNum)
+	avg := make(map[string]int64, len(stat.tmpReplyCount))
+	for key, count := range stat.tmpReplyCount {
+		avg[key] = stat.tmpReplyBytes[key] / count
+	}
+	stat.TopAvgReplyKeys = topKv(avg, topNum)
+}
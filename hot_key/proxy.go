@@ -0,0 +1,267 @@
+package hotkeys
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	proxyDialTimeout = 5 * time.Second
+	proxyBufferSize  = 32 << 10 // 单次读取的最大字节数
+	proxyQueueSize   = 10000    // 等待分析的数据段，满了之后代理读取会等待
+)
+
+// proxyEvent 代理连接上读取到的一段数据，按读取顺序交给分析线程
+type proxyEvent struct {
+	stream      *redisStream
+	request     bool   // 客户端发往 redis 的数据
+	data        []byte // 为空时表示连接建立或断开
+	closed      bool
+	receiveTime int64
+}
+
+// proxy 代理模式，转发客户端和 redis 之间的数据，由一个分析线程解析双向的 RESP
+type proxy struct {
+	target   string
+	listener net.Listener
+	factory  *redisStreamFactory
+	stat     *OverallStats
+	events   chan *proxyEvent
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{} // 正在代理的连接，停止时关闭
+	wg       sync.WaitGroup        // 连接的转发线程
+}
+
+// ShowProxy 代理模式，监听 ProxyListen 并转发到 ProxyTarget，不需要抓包权限
+// 耗时为代理读取到请求到读取到响应的时间，监控 MonitorTime 秒或 ctx 结束后输出统计
+func ShowProxy(ctx context.Context, opts *HotKeyOptions) (map[string]interface{}, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Separators != "" {
+		defer setSeparators(opts.Separators)()
+	}
+	p, err := newProxy(opts)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("代理 %s -> %s", p.listener.Addr(), p.target)
+	if opts.MonitorTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.MonitorTime)*time.Second)
+		defer cancel()
+	}
+	stat := p.run(ctx)
+	log.Infof("分析数据结束")
+	return analysisCounter(stat, opts.Top), nil
+}
+
+// newProxy 开始监听，run 之后才接受连接
+func newProxy(opts *HotKeyOptions) (*proxy, error) {
+	if opts.ProxyListen == "" || opts.ProxyTarget == "" {
+		return nil, fmt.Errorf("proxy requires listen and target address")
+	}
+	listener, err := net.Listen("tcp", opts.ProxyListen)
+	if err != nil {
+		return nil, err
+	}
+	var capacity int
+	if opts.KeyMemory > 0 {
+		capacity = hitterCapacity(opts.KeyMemory, 1, opts.CmdLen)
+	}
+	stat := newSessionStats(opts, capacity)
+	return &proxy{
+		target:   opts.ProxyTarget,
+		listener: listener,
+		factory: &redisStreamFactory{
+			cmdLen: opts.CmdLen,
+			stats:  []*OverallStats{stat},
+		},
+		stat:   stat,
+		events: make(chan *proxyEvent, proxyQueueSize),
+		conns:  map[net.Conn]struct{}{},
+	}, nil
+}
+
+// run 代理到 ctx 结束，关闭全部连接并等待分析完成
+func (p *proxy) run(ctx context.Context) *OverallStats {
+	p.stat.MonitorStartTime = time.Now().UnixMicro()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range p.events {
+			p.analysis(e)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = p.listener.Close()
+		p.mu.Lock()
+		for conn := range p.conns {
+			_ = conn.Close()
+		}
+		p.mu.Unlock()
+	}()
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("proxy accept fail, err: %v", err)
+			}
+			break
+		}
+		p.wg.Add(1)
+		go p.serve(ctx, client)
+	}
+	p.wg.Wait()
+	close(p.events)
+	<-done
+	p.stat.MonitorEndTime = time.Now().UnixMicro()
+	return p.stat
+}
+
+// track 记录连接，代理已停止时返回 false
+func (p *proxy) track(ctx context.Context, conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	for _, conn := range conns {
+		p.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (p *proxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range conns {
+		delete(p.conns, conn)
+		_ = conn.Close()
+	}
+}
+
+// serve 连接 redis 并双向转发
+func (p *proxy) serve(ctx context.Context, client net.Conn) {
+	defer p.wg.Done()
+	server, err := net.DialTimeout("tcp", p.target, proxyDialTimeout)
+	if err != nil {
+		log.Errorf("proxy dial %s fail, err: %v", p.target, err)
+		_ = client.Close()
+		return
+	}
+	if !p.track(ctx, client, server) {
+		_ = client.Close()
+		_ = server.Close()
+		return
+	}
+	s := p.newStream(client, server)
+	p.events <- &proxyEvent{stream: s}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.pipe(s, client, server, true)
+	}()
+	go func() {
+		defer wg.Done()
+		p.pipe(s, server, client, false)
+	}()
+	// 两个方向都结束后 untrack 关闭两端
+	wg.Wait()
+	p.untrack(client, server)
+	p.events <- &proxyEvent{stream: s, closed: true}
+}
+
+func (p *proxy) newStream(client, server net.Conn) *redisStream {
+	s := &redisStream{
+		factory:    p.factory,
+		requestDir: reassembly.TCPDirClientToServer,
+		monitored:  true,
+		stat:       p.stat,
+		request:    newRespReader(),
+		reply:      newRespReader(),
+	}
+	// 代理从连接建立开始解析，不需要重新对齐
+	s.request.resync = false
+	s.reply.resync = false
+	s.session.db = "0"
+	s.clientFamily = "ipv6"
+	if addr, ok := client.RemoteAddr().(*net.TCPAddr); ok {
+		s.clientIp, s.clientPort = addr.IP.String(), layers.TCPPort(addr.Port)
+		if addr.IP.To4() != nil {
+			s.clientFamily = "ipv4"
+		}
+	}
+	if addr, ok := server.RemoteAddr().(*net.TCPAddr); ok {
+		s.serverIp, s.serverPort = addr.IP.String(), layers.TCPPort(addr.Port)
+	}
+	return s
+}
+
+// pipe 单向转发，先交给分析线程再转发，保证请求在响应之前进入队列
+// 读到 EOF 时只关闭对端的写方向，另一个方向继续转发已发出请求的响应；出错时关闭两端，另一个方向随之结束
+func (p *proxy) pipe(s *redisStream, src, dst net.Conn, request bool) {
+	closeAll := func() {
+		_ = src.Close()
+		_ = dst.Close()
+	}
+	buf := make([]byte, proxyBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			p.events <- &proxyEvent{
+				stream:      s,
+				request:     request,
+				data:        data,
+				receiveTime: time.Now().UnixMicro(),
+			}
+			if _, err := dst.Write(data); err != nil {
+				closeAll()
+				return
+			}
+		}
+		if err == io.EOF {
+			if tcp, ok := dst.(*net.TCPConn); ok {
+				_ = tcp.CloseWrite()
+				return
+			}
+		}
+		if err != nil {
+			closeAll()
+			return
+		}
+	}
+}
+
+// analysis 在分析线程中解析数据，redisStream 只在这里访问
+func (p *proxy) analysis(e *proxyEvent) {
+	if e.data == nil {
+		if e.closed {
+			p.stat.CloseConnectNum++
+		} else {
+			p.stat.NewConnectNum++
+		}
+		return
+	}
+	p.stat.PacketSum++
+	captureInfo := func(int) gopacket.CaptureInfo {
+		return gopacket.CaptureInfo{Timestamp: time.UnixMicro(e.receiveTime)}
+	}
+	if e.request {
+		e.stream.readCommands(e.data, captureInfo)
+	} else {
+		e.stream.readReplies(e.data, captureInfo)
+	}
+}
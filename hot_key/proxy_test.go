@@ -0,0 +1,160 @@
+package hotkeys
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeRedis 只支持 GET 和 SET 的 RESP 服务
+func fakeRedis(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		var mu sync.Mutex
+		data := map[string]string{}
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := newRespReader()
+				r.resync = false
+				buf := make([]byte, 1024)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					r.feed(buf[:n])
+					for {
+						args, err := r.readCommand()
+						if err != nil {
+							break
+						}
+						var reply string
+						mu.Lock()
+						switch {
+						case args[0] == "SET" && len(args) == 3:
+							data[args[1]] = args[2]
+							reply = "+OK\r\n"
+						case args[0] == "GET" && len(args) == 2:
+							if v, ok := data[args[1]]; ok {
+								reply = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+							} else {
+								reply = "$-1\r\n"
+							}
+						default:
+							reply = "-ERR unknown command\r\n"
+						}
+						mu.Unlock()
+						if _, err = conn.Write([]byte(reply)); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProxy(t *testing.T) {
+	server := fakeRedis(t)
+	p, err := newProxy(&HotKeyOptions{
+		ProxyListen: "127.0.0.1:0",
+		ProxyTarget: server.Addr().String(),
+		CmdLen:      100,
+		Top:         10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan *OverallStats)
+	go func() { result <- p.run(ctx) }()
+
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pipeline 两条命令，再单独发送一条
+	_, err = conn.Write([]byte("*3\r\n$3\r\nSET\r\n$6\r\nuser:1\r\n$3\r\nbar\r\n*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "+OK\r\n$3\r\nbar\r\n"
+	got := make([]byte, len(want))
+	if _, err = io.ReadFull(conn, got); err != nil || string(got) != want {
+		t.Fatalf("got reply %q, err: %v", got, err)
+	}
+	if _, err = conn.Write([]byte("*2\r\n$3\r\nGET\r\n$6\r\nuser:2\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	got = make([]byte, len("$-1\r\n"))
+	if _, err = io.ReadFull(conn, got); err != nil || string(got) != "$-1\r\n" {
+		t.Fatalf("got reply %q, err: %v", got, err)
+	}
+	_ = conn.Close()
+	cancel()
+	stat := <-result
+
+	if stat.TotalAccessSum != 3 || stat.TotalReplyBytes != int64(len(want)+5) {
+		t.Fatalf("got total %d, reply bytes %d", stat.TotalAccessSum, stat.TotalReplyBytes)
+	}
+	if stat.latency.total != 3 {
+		t.Fatalf("got %d latency samples, want 3", stat.latency.total)
+	}
+	if stat.NewConnectNum != 1 || stat.CloseConnectNum != 1 {
+		t.Fatalf("got connections %d/%d", stat.NewConnectNum, stat.CloseConnectNum)
+	}
+	if len(stat.tmpMissPrefixes) == 0 {
+		t.Fatal("want GET miss")
+	}
+}
+
+func TestProxyHalfClose(t *testing.T) {
+	server := fakeRedis(t)
+	p, err := newProxy(&HotKeyOptions{
+		ProxyListen: "127.0.0.1:0",
+		ProxyTarget: server.Addr().String(),
+		CmdLen:      100,
+		Top:         10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan *OverallStats)
+	go func() { result <- p.run(ctx) }()
+
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 客户端发送请求后关闭写方向，仍然收到全部响应
+	if _, err = conn.Write([]byte(command("SET", "user:1", "bar") + command("GET", "user:1"))); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "+OK\r\n$3\r\nbar\r\n" {
+		t.Fatalf("got reply %q, err: %v", got, err)
+	}
+	_ = conn.Close()
+	cancel()
+	if stat := <-result; stat.TotalAccessSum != 2 || stat.latency.total != 2 {
+		t.Fatalf("got total %d, %d latency samples", stat.TotalAccessSum, stat.latency.total)
+	}
+}
@@ -0,0 +1,207 @@
+package hotkeys
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var maxChannels = 1000 // 单线程最多记录的频道和模式数量，超过时替换发布和推送最少的频道
+
+// subscribeCommands 订阅相关命令，响应以推送消息返回，不按请求顺序匹配
+var subscribeCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"SSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"SUNSUBSCRIBE": true,
+}
+
+// ChannelStats 一个频道或模式的发布和订阅统计
+type ChannelStats struct {
+	Key          string  `json:"key"`           // 频道名，模式订阅时为模式
+	Publishes    int64   `json:"publishes"`     // PUBLISH 次数
+	PublishRate  float64 `json:"publish_rate"`  // 平均每秒 PUBLISH 次数
+	PublishBytes int64   `json:"publish_bytes"` // PUBLISH 的消息字节数
+	Receivers    int64   `json:"receivers"`     // PUBLISH 响应的接收客户端数之和
+	Subscribers  int64   `json:"subscribers"`   // 订阅过的连接数
+	Messages     int64   `json:"messages"`      // 推送给订阅连接的消息数
+	FanoutBytes  int64   `json:"fanout_bytes"`  // 推送给订阅连接的字节数
+}
+
+// channelStats 获取频道，hitters 为空时不限制数量，用于聚合
+func channelStats(channels map[string]*ChannelStats, hitters *spaceSaving, key string) *ChannelStats {
+	if hitters != nil {
+		if evicted, full := hitters.admit(key, 1); full {
+			delete(channels, evicted)
+		}
+	}
+	s, ok := channels[key]
+	if !ok {
+		s = &ChannelStats{Key: key}
+		channels[key] = s
+	}
+	return s
+}
+
+// trackSubscribe 跟踪连接上的订阅，订阅命令的响应以推送消息返回，不等待响应时返回 true
+func (s *redisStream) trackSubscribe(c *redisCommand) bool {
+	if !subscribeCommands[c.cmd] {
+		return false
+	}
+	if !strings.HasSuffix(c.cmd, "UNSUBSCRIBE") {
+		for _, channel := range c.args[1:] {
+			s.subscribe(channel, c.cmd == "PSUBSCRIBE")
+		}
+	}
+	return true
+}
+
+// subscribe 记录连接订阅了频道或模式，每个连接只计一次
+func (s *redisStream) subscribe(channel string, pattern bool) {
+	session := s.connSession()
+	session.subscribed = true
+	key := "channel:" + channel
+	channels, hitters := s.stat.tmpChannels, s.stat.channelHitters
+	if pattern {
+		key = "pattern:" + channel
+		channels, hitters = s.stat.tmpPatterns, s.stat.patternHitters
+	}
+	if session.channels == nil {
+		session.channels = map[string]bool{}
+	}
+	if session.channels[key] {
+		return
+	}
+	session.channels[key] = true
+	channelStats(channels, hitters, channel).Subscribers++
+}
+
+// pushPrefixes 推送消息的开头，用于中途抓到的订阅连接对齐响应
+var pushPrefixes = [][]byte{
+	[]byte("*3\r\n$7\r\nmessage\r\n"),
+	[]byte("*3\r\n$8\r\nsmessage\r\n"),
+	[]byte("*4\r\n$8\r\npmessage\r\n"),
+	[]byte(">3\r\n"),
+	[]byte(">4\r\n"),
+}
+
+func pushPrefix(data []byte) bool {
+	for _, prefix := range pushPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushInfo 统计 redis 推送给订阅连接的消息，不是推送消息时返回 false
+func (s *redisStream) pushInfo(reply *respValue) bool {
+	if reply.kind != '>' && reply.kind != '*' {
+		return false
+	}
+	if len(reply.elems) < 2 {
+		return reply.kind == '>'
+	}
+	kind := strings.ToLower(reply.elems[0].str)
+	// RESP2 中订阅过的连接才把数组当作推送消息，中途抓到的订阅连接没有等待响应的命令
+	if reply.kind == '*' && !s.connSession().subscribed && (len(s.pending) > 0 || !strings.HasSuffix(kind, "message")) {
+		return false
+	}
+	switch kind {
+	case "message", "smessage":
+		// message channel payload
+		s.subscribe(reply.elems[1].str, false)
+		fanoutInfo(s.stat.tmpChannels, s.stat.channelHitters, reply.elems[1].str, reply.size)
+	case "pmessage":
+		// pmessage pattern channel payload
+		if len(reply.elems) < 3 {
+			return true
+		}
+		s.subscribe(reply.elems[1].str, true)
+		fanoutInfo(s.stat.tmpPatterns, s.stat.patternHitters, reply.elems[1].str, reply.size)
+		fanoutInfo(s.stat.tmpChannels, s.stat.channelHitters, reply.elems[2].str, reply.size)
+	case "subscribe", "ssubscribe":
+		s.subscribe(reply.elems[1].str, false)
+	case "psubscribe":
+		s.subscribe(reply.elems[1].str, true)
+	case "unsubscribe", "sunsubscribe", "punsubscribe":
+	default:
+		// 订阅状态下 PING 的响应等普通数组
+		return reply.kind == '>'
+	}
+	return true
+}
+
+func fanoutInfo(channels map[string]*ChannelStats, hitters *spaceSaving, channel string, size int) {
+	c := channelStats(channels, hitters, channel)
+	c.Messages++
+	c.FanoutBytes += int64(size)
+}
+
+// publishInfo 统计 PUBLISH 和 SPUBLISH 的消息
+func publishInfo(c *redisCommand, stat *OverallStats) {
+	if (c.cmd != "PUBLISH" && c.cmd != "SPUBLISH") || len(c.args) != 3 {
+		return
+	}
+	s := channelStats(stat.tmpChannels, stat.channelHitters, c.args[1])
+	s.Publishes++
+	s.PublishBytes += int64(len(c.args[2]))
+}
+
+// publishReplyInfo PUBLISH 的响应为收到消息的客户端数
+func publishReplyInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	if (c.cmd != "PUBLISH" && c.cmd != "SPUBLISH") || len(c.args) != 3 || reply.kind != ':' {
+		return
+	}
+	n, err := strconv.ParseInt(reply.str, 10, 64)
+	if err != nil {
+		return
+	}
+	if s, ok := stat.tmpChannels[c.args[1]]; ok {
+		s.Receivers += n
+	}
+}
+
+func aggregationChannels(channels map[string]*ChannelStats, newChannels map[string]*ChannelStats) {
+	for key, value := range newChannels {
+		s := channelStats(channels, nil, key)
+		s.Publishes += value.Publishes
+		s.PublishBytes += value.PublishBytes
+		s.Receivers += value.Receivers
+		s.Subscribers += value.Subscribers
+		s.Messages += value.Messages
+		s.FanoutBytes += value.FanoutBytes
+	}
+}
+
+func aggregationPubSub(stat *OverallStats, newStat *OverallStats) {
+	aggregationChannels(stat.tmpChannels, newStat.tmpChannels)
+	aggregationChannels(stat.tmpPatterns, newStat.tmpPatterns)
+}
+
+// analysisChannels 按发布和推送的总字节数排序
+func analysisChannels(channels map[string]*ChannelStats, seconds float64, topNum int) []*ChannelStats {
+	res := make([]*ChannelStats, 0, len(channels))
+	for _, s := range channels {
+		if seconds > 0 {
+			s.PublishRate = Decimal(float64(s.Publishes) / seconds)
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].PublishBytes+res[i].FanoutBytes > res[j].PublishBytes+res[j].FanoutBytes
+	})
+	if len(res) > topNum {
+		res = res[:topNum]
+	}
+	return res
+}
+
+func analysisPubSub(stat *OverallStats, topNum int) {
+	seconds := float64(stat.MonitorEndTime-stat.MonitorStartTime) / 1000 / 1000
+	stat.PubSubChannels = analysisChannels(stat.tmpChannels, seconds, topNum)
+	stat.PubSubPatterns = analysisChannels(stat.tmpPatterns, seconds, topNum)
+}
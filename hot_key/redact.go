@@ -0,0 +1,203 @@
+package hotkeys
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	redactMask    = "***"     // 密码等敏感参数的替换值
+	redactHashLen = 16        // 哈希值保留的十六进制字符数
+	redactPrefix  = "sha256:" // 哈希后的参数前缀
+)
+
+// secretConfigs CONFIG SET 时值需要隐藏的配置
+var secretConfigs = map[string]bool{
+	"requirepass":              true,
+	"masterauth":               true,
+	"tls-key-file-pass":        true,
+	"tls-client-key-file-pass": true,
+}
+
+// redactPolicy 命令写入文件前的脱敏策略，密码等敏感参数总是隐藏
+type redactPolicy struct {
+	values bool             // 保留 key，其它参数替换为哈希值
+	rules  []*regexp.Regexp // 匹配的内容替换为 ***
+}
+
+func newRedactPolicy(values bool, rules []string) (*redactPolicy, error) {
+	p := &redactPolicy{values: values}
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact rule %q: %v", rule, err)
+		}
+		p.rules = append(p.rules, re)
+	}
+	return p, nil
+}
+
+// redact 返回脱敏后的参数，不修改 args
+func (p *redactPolicy) redact(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	cmd := strings.ToUpper(args[0])
+	// 隐藏和哈希后的参数不再匹配正则
+	masked := maskSecrets(cmd, out)
+	if p.values && !keepArgs(cmd) {
+		keep := keyPositions(cmd, args)
+		for i := 1; i < len(out); i++ {
+			if !keep[i] && !masked[i] {
+				out[i] = hashValue(out[i])
+				masked[i] = true
+			}
+		}
+	}
+	for i := 1; i < len(out); i++ {
+		if masked[i] {
+			continue
+		}
+		for _, re := range p.rules {
+			out[i] = re.ReplaceAllString(out[i], redactMask)
+		}
+	}
+	return out
+}
+
+// hashValue 相同的值哈希后相同，可以按值关联但无法还原
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return redactPrefix + hex.EncodeToString(sum[:])[:redactHashLen]
+}
+
+// keepArgs 只有管理参数的命令，例如 CONFIG、INFO、KEYS，参数不需要哈希
+func keepArgs(cmd string) bool {
+	flags := commandTable[cmd].flags
+	return flags&flagAdmin != 0 && flags&flagWrite == 0
+}
+
+// keyPositions 标记 key 和 numkeys 参数的位置，XREAD 的选项也保留
+func keyPositions(cmd string, args []string) []bool {
+	keep := make([]bool, len(args))
+	for _, i := range commandKeyIndexes(cmd, args) {
+		keep[i] = true
+	}
+	if cmd == "XREAD" || cmd == "XREADGROUP" {
+		for i := 1; i < len(args); i++ {
+			keep[i] = true
+			if strings.ToUpper(args[i]) == "STREAMS" {
+				break
+			}
+		}
+	}
+	if info, ok := commandTable[cmd]; ok && info.keys != nil && info.keys.numkeys > 0 && info.keys.numkeys < len(args) {
+		keep[info.keys.numkeys] = true
+	}
+	return keep
+}
+
+// maskSecrets 隐藏密码参数并返回隐藏的位置，和脱敏策略无关
+func maskSecrets(cmd string, args []string) []bool {
+	masked := make([]bool, len(args))
+	mask := func(i int) {
+		if i > 0 && i < len(args) {
+			args[i] = redactMask
+			masked[i] = true
+		}
+	}
+	switch cmd {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			mask(i)
+		}
+	case "HELLO":
+		// HELLO protover AUTH username password
+		for i := 2; i < len(args); i++ {
+			if strings.ToUpper(args[i]) == "AUTH" {
+				mask(i + 2)
+				break
+			}
+		}
+	case "MIGRATE":
+		// MIGRATE host port key db timeout [AUTH password | AUTH2 username password] [KEYS key...]
+		for i := 6; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				mask(i + 1)
+			case "AUTH2":
+				mask(i + 2)
+			case "KEYS":
+				return masked
+			}
+		}
+	case "CONFIG":
+		if len(args) < 2 || strings.ToUpper(args[1]) != "SET" {
+			break
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			if secretConfigs[strings.ToLower(args[i])] {
+				mask(i + 1)
+			}
+		}
+	case "ACL":
+		if len(args) < 2 || strings.ToUpper(args[1]) != "SETUSER" {
+			break
+		}
+		// >password <password #hash !hash 为密码规则
+		for i := 3; i < len(args); i++ {
+			if args[i] != "" && strings.IndexByte("><#!", args[i][0]) >= 0 {
+				mask(i)
+			}
+		}
+	}
+	return masked
+}
+
+// commandWriter 多个分析线程共用的命令文件，写入前按策略脱敏
+type commandWriter struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	policy *redactPolicy
+}
+
+func newCommandWriter(w *bufio.Writer, policy *redactPolicy) *commandWriter {
+	return &commandWriter{w: w, policy: policy}
+}
+
+// write 一条命令写入一行 FullKey 的 JSON
+func (cw *commandWriter) write(c *redisCommand, src, dst string) {
+	args := cw.policy.redact(c.args)
+	cmdBuf := &FullKey{
+		FullCmd:     strings.Join(args, " "),
+		Args:        args,
+		ReceiveTime: c.receiveTime,
+		Src:         src,
+		Dst:         dst,
+	}
+	tmpStr, err := json.Marshal(cmdBuf)
+	if err != nil {
+		log.Warnf("%v", err)
+		return
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if _, err = cw.w.Write(tmpStr); err == nil {
+		err = cw.w.WriteByte('\n')
+	}
+	if err != nil {
+		log.Warnf("wirte file fail:%v", err)
+	}
+}
+
+func (cw *commandWriter) flush() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.w.Flush()
+}
@@ -0,0 +1,102 @@
+package hotkeys
+
+import (
+	"sort"
+)
+
+// missCommands 统计未命中率的读命令
+var missCommands = map[string]bool{
+	"GET":    true,
+	"GETEX":  true,
+	"GETDEL": true,
+}
+
+// replyInfo 统计一条命令的响应
+func replyInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	if c.ignore {
+		return
+	}
+	// 响应大小
+	replyBytesInfo(c, reply, stat)
+	execReplyInfo(c, reply, stat)
+	publishReplyInfo(c, reply, stat)
+	blockingReplyInfo(c, reply, stat)
+	riskyReplyInfo(c, reply, stat)
+	// 错误响应，按错误前缀分类
+	if reply.kind == '-' || reply.kind == '!' {
+		stat.TotalErrorSum++
+		redirectInfo(c, reply, stat)
+		scriptReplyInfo(c, reply, stat)
+		errKey := c.cmd + " " + reply.errPrefix()
+		if foundKv(stat.ErrorCommands, errKey) {
+			modifyKv(stat.ErrorCommands, errKey, 1)
+		} else {
+			stat.ErrorCommands = addKv(stat.ErrorCommands, errKey, 1)
+		}
+		return
+	}
+	// GET 未命中，事务中的命令响应为 QUEUED，结果在 EXEC 中
+	if missCommands[c.cmd] && c.key != "" && !c.inMulti {
+		for _, prefix := range getPrefixes(c.key, separators) {
+			if len(prefix) == 0 {
+				continue
+			}
+			if stat.missHitters != nil {
+				// 近似统计时按 GET 次数保留前缀，未命中次数作为附加计数
+				e := stat.missHitters.add(prefix, 1)
+				if reply.isNil {
+					e.aux++
+				}
+				continue
+			}
+			ratio, ok := stat.tmpMissPrefixes[prefix]
+			if !ok {
+				ratio = &HitRatio{Key: prefix}
+				stat.tmpMissPrefixes[prefix] = ratio
+			}
+			ratio.Total++
+			if reply.isNil {
+				ratio.Miss++
+			}
+		}
+	}
+}
+
+func aggregationReply(stat *OverallStats, newStat *OverallStats) {
+	stat.TotalErrorSum += newStat.TotalErrorSum
+	for _, value := range newStat.ErrorCommands {
+		if foundKv(stat.ErrorCommands, value.Key) {
+			modifyKv(stat.ErrorCommands, value.Key, value.Value)
+		} else {
+			stat.ErrorCommands = addKv(stat.ErrorCommands, value.Key, value.Value)
+		}
+	}
+	for key, value := range newStat.tmpMissPrefixes {
+		if ratio, ok := stat.tmpMissPrefixes[key]; ok {
+			ratio.Total += value.Total
+			ratio.Miss += value.Miss
+		} else {
+			stat.tmpMissPrefixes[key] = &HitRatio{Key: key, Total: value.Total, Miss: value.Miss}
+		}
+	}
+}
+
+func analysisReply(stat *OverallStats, topNum int) {
+	sort.Slice(stat.ErrorCommands, func(i, j int) bool { return stat.ErrorCommands[i].Value > stat.ErrorCommands[j].Value })
+	if len(stat.ErrorCommands) > topNum {
+		stat.ErrorCommands = stat.ErrorCommands[:topNum]
+	}
+	for _, ratio := range stat.tmpMissPrefixes {
+		stat.MissPrefixes = append(stat.MissPrefixes, ratio)
+	}
+	if stat.missHitters != nil {
+		stat.MissPrefixes = missHitterRatios(stat)
+	}
+	for _, ratio := range stat.MissPrefixes {
+		ratio.Ratio = Decimal(float64(ratio.Miss) / float64(ratio.Total))
+	}
+	sort.Slice(stat.MissPrefixes, func(i, j int) bool { return stat.MissPrefixes[i].Miss > stat.MissPrefixes[j].Miss })
+	if len(stat.MissPrefixes) > topNum {
+		stat.MissPrefixes = stat.MissPrefixes[:topNum]
+	}
+}
@@ -0,0 +1,348 @@
+package hotkeys
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errIncomplete = errors.New("resp: incomplete data")
+	errProtocol   = errors.New("resp: protocol error")
+)
+
+const (
+	maxBulkLength   = 512 << 20 // redis proto-max-bulk-len 默认值
+	maxMultiBulk    = 1024 * 1024
+	maxInlineLength = 64 << 10 // redis PROTO_INLINE_MAX_SIZE
+)
+
+// respReader RESP2 增量解析，一个TCP方向一个实例
+// 数据按重组后的字节流写入，可以解析 pipeline、跨包命令以及包含 \r\n 的二进制值
+type respReader struct {
+	buf    []byte
+	resync bool // 中途抓包或丢包后需要重新对齐到 '*' 开头的命令
+	// 请求解析状态，跨包的命令从上次的位置继续解析
+	args     []string // 已读取的参数
+	argCount int      // 命令的参数个数，还没有读取 *<n> 行时为 0
+	argPos   int      // 已解析的字节数
+	// 响应解析状态
+	stack    []*respValue // 未解析完的数组
+	bulk     *respValue   // 正在读取的批量字符串
+	bulkLeft int          // 批量字符串剩余字节数，包含结尾的 \r\n
+	size     int          // 当前响应已解析的字节数
+}
+
+func newRespReader() *respReader {
+	return &respReader{resync: true}
+}
+
+func (r *respReader) feed(data []byte) {
+	r.buf = append(r.buf, data...)
+}
+
+// reset 丢弃已缓存的数据，等待下一个命令起始位置
+func (r *respReader) reset() {
+	r.buf = nil
+	r.resync = true
+	r.args = nil
+	r.argCount = 0
+	r.argPos = 0
+	r.stack = nil
+	r.bulk = nil
+	r.bulkLeft = 0
+	r.size = 0
+}
+
+// buffered 当前缓存未解析的字节数
+func (r *respReader) buffered() int {
+	return len(r.buf)
+}
+
+// idle 没有解析到一半的响应
+func (r *respReader) idle() bool {
+	return len(r.buf) == 0 && r.bulk == nil && len(r.stack) == 0
+}
+
+// readCommand 读取一条完整的命令，数据不足时返回 errIncomplete
+// 协议错误时会清空缓存并重新对齐
+func (r *respReader) readCommand() ([]string, error) {
+	for {
+		if r.resync && !r.align() {
+			return nil, errIncomplete
+		}
+		if len(r.buf) == 0 {
+			return nil, errIncomplete
+		}
+		if r.buf[0] == '*' {
+			args, n, err := r.readMultiBulk()
+			if err == errProtocol {
+				r.reset()
+				return nil, err
+			}
+			if err != nil {
+				return nil, err
+			}
+			r.buf = r.buf[n:]
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+		// 内联命令，例如 telnet 直接输入
+		idx := bytes.IndexByte(r.buf, '\n')
+		if idx < 0 {
+			if len(r.buf) > maxInlineLength {
+				r.reset()
+				return nil, errProtocol
+			}
+			return nil, errIncomplete
+		}
+		line := strings.TrimRight(string(r.buf[:idx]), "\r")
+		r.buf = r.buf[idx+1:]
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		return args, nil
+	}
+}
+
+// align 跳到下一个以 '*' 开头的行，找不到时只保留最后一个不完整的行
+func (r *respReader) align() bool {
+	for i := 0; i < len(r.buf); i++ {
+		if r.buf[i] == '*' && (i == 0 || r.buf[i-1] == '\n') {
+			r.buf = r.buf[i:]
+			r.resync = false
+			return true
+		}
+	}
+	if idx := bytes.LastIndexByte(r.buf, '\n'); idx >= 0 {
+		r.buf = r.buf[idx+1:]
+	}
+	if len(r.buf) > 0 && r.buf[0] != '*' {
+		r.buf = nil
+	}
+	return false
+}
+
+// readLine 读取 \r\n 结尾的一行，返回行内容和下一行的起始位置
+func readLine(buf []byte, pos int) ([]byte, int, error) {
+	idx := bytes.Index(buf[pos:], []byte("\r\n"))
+	if idx < 0 {
+		if len(buf)-pos > maxInlineLength {
+			return nil, 0, errProtocol
+		}
+		return nil, 0, errIncomplete
+	}
+	return buf[pos : pos+idx], pos + idx + 2, nil
+}
+
+// readLength 解析 *<n> 或 $<n> 行中的长度
+func readLength(line []byte, prefix byte) (int, error) {
+	if len(line) < 2 || line[0] != prefix {
+		return 0, errProtocol
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+// readMultiBulk 解析 *<n>\r\n$<len>\r\n<data>\r\n... 格式的请求，返回命令和占用的字节数
+// 数据不足时保留已读取的参数和位置，大的命令跨多个包时不会从头重复解析
+func (r *respReader) readMultiBulk() ([]string, int, error) {
+	buf := r.buf
+	if r.argCount == 0 {
+		line, pos, err := readLine(buf, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		count, err := readLength(line, '*')
+		if err != nil {
+			return nil, 0, err
+		}
+		if count > maxMultiBulk {
+			return nil, 0, errProtocol
+		}
+		if count <= 0 {
+			return nil, pos, nil
+		}
+		r.args, r.argCount, r.argPos = make([]string, 0, count), count, pos
+	}
+	for len(r.args) < r.argCount {
+		line, pos, err := readLine(buf, r.argPos)
+		if err != nil {
+			return nil, 0, err
+		}
+		size, err := readLength(line, '$')
+		if err != nil || size < 0 || size > maxBulkLength {
+			return nil, 0, errProtocol
+		}
+		if len(buf) < pos+size+2 {
+			return nil, 0, errIncomplete
+		}
+		if buf[pos+size] != '\r' || buf[pos+size+1] != '\n' {
+			return nil, 0, errProtocol
+		}
+		r.args = append(r.args, string(buf[pos:pos+size]))
+		r.argPos = pos + size + 2
+	}
+	args, n := r.args, r.argPos
+	r.args, r.argCount, r.argPos = nil, 0, 0
+	return args, n, nil
+}
+
+const (
+	maxKeepLength = 512 // 响应中保留的字符串最大长度
+	maxKeepElems  = 128 // 响应中保留的数组元素个数
+	maxReplyDepth = 32
+)
+
+// respValue 一个完整的响应值
+type respValue struct {
+	kind  byte   // 类型前缀 + - : $ * 以及 RESP3 类型
+	isNil bool   // $-1、*-1 或 RESP3 的 _
+	str   string // 简单字符串、错误、整数或批量字符串内容，超长截断
+	size  int    // 顶层响应编码后的字节数
+	count int    // 聚合类型的元素个数，elems 只保留前 maxKeepElems 个
+	elems []*respValue
+	left  int // 解析中聚合类型剩余的元素个数
+}
+
+// errPrefix 错误响应的前缀，例如 MOVED、WRONGTYPE
+func (v *respValue) errPrefix() string {
+	if idx := strings.IndexByte(v.str, ' '); idx > 0 {
+		return v.str[:idx]
+	}
+	return v.str
+}
+
+func isReplyType(c byte) bool {
+	return strings.IndexByte("+-:$*_#,(=!%~>", c) >= 0
+}
+
+// readReply 读取一个完整的响应，数据不足时返回 errIncomplete
+// 响应按流式解析，大的批量字符串和数组不会整体缓存
+func (r *respReader) readReply() (*respValue, error) {
+	if r.resync && len(r.stack) == 0 && r.bulk == nil {
+		// 响应无法可靠对齐，只从以类型前缀开头的数据恢复
+		if len(r.buf) == 0 {
+			return nil, errIncomplete
+		}
+		if !isReplyType(r.buf[0]) {
+			r.buf = nil
+			return nil, errIncomplete
+		}
+		r.resync = false
+	}
+	for {
+		if r.bulk != nil {
+			n := len(r.buf)
+			if n > r.bulkLeft {
+				n = r.bulkLeft
+			}
+			take := r.bulkLeft - 2
+			if take > n {
+				take = n
+			}
+			if keep := maxKeepLength - len(r.bulk.str); take > keep {
+				take = keep
+			}
+			if take > 0 {
+				r.bulk.str += string(r.buf[:take])
+			}
+			r.buf = r.buf[n:]
+			r.bulkLeft -= n
+			r.size += n
+			if r.bulkLeft > 0 {
+				return nil, errIncomplete
+			}
+			v := r.bulk
+			r.bulk = nil
+			if done := r.complete(v); done != nil {
+				return done, nil
+			}
+			continue
+		}
+		line, next, err := readLine(r.buf, 0)
+		if err == nil && len(line) == 0 {
+			err = errProtocol
+		}
+		if err == errProtocol {
+			r.reset()
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		v := &respValue{kind: line[0]}
+		switch v.kind {
+		case '+', '-', ':', '#', ',', '(':
+			v.str = string(line[1:])
+		case '_':
+			v.isNil = true
+		case '$', '=', '!':
+			size, err := readLength(line, v.kind)
+			if err != nil || size > maxBulkLength {
+				r.reset()
+				return nil, errProtocol
+			}
+			if size < 0 {
+				v.isNil = true
+				break
+			}
+			r.bulk, r.bulkLeft = v, size+2
+		case '*', '%', '~', '>':
+			count, err := readLength(line, v.kind)
+			if err != nil || count > maxMultiBulk || len(r.stack) >= maxReplyDepth {
+				r.reset()
+				return nil, errProtocol
+			}
+			if count < 0 {
+				v.isNil = true
+				break
+			}
+			if v.kind == '%' {
+				count *= 2
+			}
+			v.count, v.left = count, count
+		default:
+			r.reset()
+			return nil, errProtocol
+		}
+		r.buf = r.buf[next:]
+		r.size += next
+		if r.bulk != nil {
+			continue
+		}
+		if v.left > 0 {
+			r.stack = append(r.stack, v)
+			continue
+		}
+		if done := r.complete(v); done != nil {
+			return done, nil
+		}
+	}
+}
+
+// complete 把解析完成的值放入上层数组，返回完整的顶层响应
+func (r *respReader) complete(v *respValue) *respValue {
+	for len(r.stack) > 0 {
+		parent := r.stack[len(r.stack)-1]
+		if len(parent.elems) < maxKeepElems {
+			parent.elems = append(parent.elems, v)
+		}
+		parent.left--
+		if parent.left > 0 {
+			return nil
+		}
+		r.stack = r.stack[:len(r.stack)-1]
+		v = parent
+	}
+	v.size = r.size
+	r.size = 0
+	return v
+}
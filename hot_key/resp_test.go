@@ -0,0 +1,135 @@
+package hotkeys
+
+import (
+	"reflect"
+	"testing"
+)
+
+func readAll(r *respReader) [][]string {
+	var cmds [][]string
+	for {
+		args, err := r.readCommand()
+		if err == errIncomplete {
+			return cmds
+		}
+		if err != nil {
+			continue
+		}
+		cmds = append(cmds, args)
+	}
+}
+
+func TestRespReaderPipeline(t *testing.T) {
+	r := newRespReader()
+	r.feed([]byte("*2\r\n$3\r\nGET\r\n$2\r\nk1\r\n*3\r\n$3\r\nSET\r\n$2\r\nk2\r\n$4\r\na\r\nb\r\n*1\r\n$4\r\nPING\r\n"))
+	want := [][]string{{"GET", "k1"}, {"SET", "k2", "a\r\nb"}, {"PING"}}
+	if got := readAll(r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRespReaderSplit(t *testing.T) {
+	r := newRespReader()
+	data := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+	var got [][]string
+	for i := range data {
+		r.feed(data[i : i+1])
+		got = append(got, readAll(r)...)
+	}
+	want := [][]string{{"SET", "key", "value"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRespReaderResume(t *testing.T) {
+	r := newRespReader()
+	r.feed([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$10\r\nval"))
+	if got := readAll(r); len(got) != 0 {
+		t.Fatalf("got %q before the value is complete", got)
+	}
+	// 已读取的参数保留，后续的包从上次的位置继续解析
+	if !reflect.DeepEqual(r.args, []string{"SET", "key"}) || r.argPos != 22 {
+		t.Fatalf("got args %q pos %d", r.args, r.argPos)
+	}
+	r.feed([]byte("ue12345\r\n*1\r\n$4\r\nPING\r\n"))
+	want := [][]string{{"SET", "key", "value12345"}, {"PING"}}
+	if got := readAll(r); !reflect.DeepEqual(got, want) || r.argCount != 0 {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	// 协议错误时丢弃已读取的参数
+	r.feed([]byte("*2\r\n$3\r\nGET\r\n$x\r\n"))
+	if got := readAll(r); len(got) != 0 || r.args != nil || r.argCount != 0 {
+		t.Fatalf("got %q, args %q after protocol error", got, r.args)
+	}
+}
+
+func TestRespReaderInline(t *testing.T) {
+	r := newRespReader()
+	r.resync = false
+	r.feed([]byte("PING\r\nget  foo\n\r\n*1\r\n$4\r\nQUIT\r\n"))
+	want := [][]string{{"PING"}, {"get", "foo"}, {"QUIT"}}
+	if got := readAll(r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRespReaderResync(t *testing.T) {
+	// 从一个命令中间开始抓包
+	r := newRespReader()
+	r.feed([]byte("lue\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	want := [][]string{{"GET", "k"}}
+	if got := readAll(r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	// 协议错误后丢弃并重新对齐
+	r.feed([]byte("*2\r\n$x\r\n*1\r\n$4\r\nPING\r\n"))
+	if got := readAll(r); len(got) != 0 {
+		t.Fatalf("got %q after protocol error", got)
+	}
+	r.feed([]byte("*1\r\n$4\r\nPING\r\n"))
+	want = [][]string{{"PING"}}
+	if got := readAll(r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRespReaderReply(t *testing.T) {
+	data := "+OK\r\n-MOVED 3999 127.0.0.1:6381\r\n:12\r\n$-1\r\n$5\r\nab\r\nc\r\n*2\r\n*1\r\n:1\r\n$3\r\nfoo\r\n*-1\r\n"
+	// 逐字节写入，验证流式解析
+	r := newRespReader()
+	r.resync = false
+	var replies []*respValue
+	for i := 0; i < len(data); i++ {
+		r.feed([]byte{data[i]})
+		for {
+			v, err := r.readReply()
+			if err != nil {
+				break
+			}
+			replies = append(replies, v)
+		}
+	}
+	if len(replies) != 7 {
+		t.Fatalf("got %d replies, want 7", len(replies))
+	}
+	if replies[1].kind != '-' || replies[1].errPrefix() != "MOVED" {
+		t.Fatalf("got error reply %q", replies[1].str)
+	}
+	if !replies[3].isNil || !replies[6].isNil {
+		t.Fatal("nil reply not detected")
+	}
+	if replies[4].str != "ab\r\nc" || replies[4].size != 11 {
+		t.Fatalf("got bulk %q size %d", replies[4].str, replies[4].size)
+	}
+	if replies[5].count != 2 || replies[5].elems[0].elems[0].str != "1" || replies[5].elems[1].str != "foo" {
+		t.Fatalf("got array %+v", replies[5])
+	}
+	total := 0
+	for _, v := range replies {
+		total += v.size
+	}
+	if total != len(data) {
+		t.Fatalf("got total size %d, want %d", total, len(data))
+	}
+}
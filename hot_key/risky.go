@@ -0,0 +1,241 @@
+package hotkeys
+
+import (
+	"container/heap"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const riskyKeepArgs = 4 // 危险命令报告中保留的参数个数
+
+var (
+	maxRiskyCalls            = 1000      // 单线程最多记录的危险命令数量，只保留耗时最长的
+	riskyArgs                = 100       // 参数个数达到后为危险命令，例如 MGET、MSET、DEL
+	riskyScanCount           = 1000      // SCAN 的 COUNT 达到后为危险命令
+	riskyReplyElements       = 1000      // O(N) 读命令响应的元素个数达到后为危险命令
+	riskyDelTime       int64 = 10 * 1000 // DEL 耗时达到后认为删除了大 key，微秒
+)
+
+// 危险命令的原因
+const (
+	riskKeys       = "keys"        // KEYS 遍历全部 key
+	riskFlush      = "flush"       // FLUSHALL 和 FLUSHDB
+	riskScanCount  = "scan_count"  // SCAN 的 COUNT 过大
+	riskManyArgs   = "many_args"   // 参数过多
+	riskFullRead   = "full_read"   // 读取整个集合，例如 HGETALL、SMEMBERS
+	riskLargeRange = "large_range" // 范围过大，例如 LRANGE 0 -1
+	riskSlowDelete = "slow_delete" // DEL 耗时过长，可能删除了大 key
+)
+
+// fullReadCommands 读取整个集合的命令，响应的元素个数过多时为危险命令
+var fullReadCommands = map[string]bool{
+	"HGETALL":  true,
+	"HKEYS":    true,
+	"HVALS":    true,
+	"SMEMBERS": true,
+	"SINTER":   true,
+	"SUNION":   true,
+	"SDIFF":    true,
+}
+
+// rangeCommands 按范围读取的命令，响应的元素个数过多时为危险命令
+var rangeCommands = map[string]bool{
+	"LRANGE":           true,
+	"ZRANGE":           true,
+	"ZREVRANGE":        true,
+	"ZRANGEBYSCORE":    true,
+	"ZREVRANGEBYSCORE": true,
+	"ZRANGEBYLEX":      true,
+	"ZREVRANGEBYLEX":   true,
+	"XRANGE":           true,
+	"XREVRANGE":        true,
+}
+
+// scanCommands 带 COUNT 参数的遍历命令
+var scanCommands = map[string]bool{
+	"SCAN":  true,
+	"HSCAN": true,
+	"SSCAN": true,
+	"ZSCAN": true,
+}
+
+// RiskyCall 一次危险或 O(N) 命令调用
+type RiskyCall struct {
+	Reason     string `json:"reason"`      // 危险的原因
+	Command    string `json:"command"`     // 命令和前几个参数
+	Client     string `json:"client"`      // 客户端地址
+	Time       int64  `json:"time"`        // 接收时间，时间戳，微秒
+	Args       int    `json:"args"`        // 参数个数，不包括命令
+	ReplyBytes int64  `json:"reply_bytes"` // 响应字节数，没有匹配到响应时为 0
+	ReplyCount int    `json:"reply_count"` // 响应的元素个数
+	Latency    int64  `json:"latency"`     // 耗时，微秒
+	recorded   bool
+	index      int // 在 riskyHeap 中的位置，不在堆中时为 -1
+}
+
+// riskyLess 耗时短的在前，耗时相同时响应字节数少的在前
+func riskyLess(a, b *RiskyCall) bool {
+	if a.Latency != b.Latency {
+		return a.Latency < b.Latency
+	}
+	return a.ReplyBytes < b.ReplyBytes
+}
+
+// riskyHeap 按耗时排序的最小堆
+type riskyHeap []*RiskyCall
+
+func (h riskyHeap) Len() int           { return len(h) }
+func (h riskyHeap) Less(i, j int) bool { return riskyLess(h[i], h[j]) }
+func (h riskyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *riskyHeap) Push(x interface{}) {
+	r := x.(*RiskyCall)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+func (h *riskyHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	r.index = -1
+	*h = old[:len(old)-1]
+	return r
+}
+
+// keepRisky 只保留耗时最长的 maxRiskyCalls 条，满了之后替换耗时最短的
+// 调用的耗时和响应在记录之后才知道，已在堆中的调用重新调整位置
+func keepRisky(h *riskyHeap, r *RiskyCall) {
+	if r.index >= 0 {
+		heap.Fix(h, r.index)
+		return
+	}
+	if len(*h) < maxRiskyCalls {
+		heap.Push(h, r)
+		return
+	}
+	if len(*h) == 0 || !riskyLess((*h)[0], r) {
+		return
+	}
+	(*h)[0].index = -1
+	r.index = 0
+	(*h)[0] = r
+	heap.Fix(h, 0)
+}
+
+// riskyReason 命令的危险原因，confirm 为 true 时需要按响应或耗时确认
+func riskyReason(c *redisCommand) (reason string, confirm bool) {
+	switch {
+	case c.cmd == "KEYS":
+		return riskKeys, false
+	case c.cmd == "FLUSHALL" || c.cmd == "FLUSHDB":
+		return riskFlush, false
+	case len(c.args)-1 >= riskyArgs:
+		return riskManyArgs, false
+	case scanCommands[c.cmd]:
+		for i := 1; i+1 < len(c.args); i++ {
+			if strings.ToUpper(c.args[i]) != "COUNT" {
+				continue
+			}
+			if n, err := strconv.Atoi(c.args[i+1]); err == nil && n >= riskyScanCount {
+				return riskScanCount, false
+			}
+		}
+	case fullReadCommands[c.cmd]:
+		return riskFullRead, true
+	case rangeCommands[c.cmd]:
+		return riskLargeRange, true
+	case c.cmd == "DEL":
+		return riskSlowDelete, true
+	}
+	return "", false
+}
+
+// riskyInfo 记录危险命令，需要确认的命令在响应或耗时满足条件后记录
+func riskyInfo(c *redisCommand, client string, stat *OverallStats) {
+	reason, confirm := riskyReason(c)
+	if reason == "" {
+		return
+	}
+	args := c.args
+	if len(args) > riskyKeepArgs {
+		args = args[:riskyKeepArgs]
+	}
+	command := strings.Join(args, " ")
+	if len(command) > maxKeepLength {
+		command = command[:maxKeepLength]
+	}
+	c.risky = &RiskyCall{
+		Reason:  reason,
+		Command: command,
+		Client:  client,
+		Time:    c.receiveTime,
+		Args:    len(c.args) - 1,
+		index:   -1,
+	}
+	if !confirm {
+		recordRisky(c.risky, stat)
+	}
+}
+
+// recordRisky 记录确认的危险命令，已记录的命令在耗时或响应更新后重新参与排序
+func recordRisky(r *RiskyCall, stat *OverallStats) {
+	if !r.recorded {
+		r.recorded = true
+		stat.tmpRiskyReasons[r.Reason]++
+	}
+	keepRisky(&stat.tmpRiskyCalls, r)
+}
+
+// riskyReplyInfo 记录危险命令的响应，读取的元素过多时确认
+func riskyReplyInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	if c.risky == nil {
+		return
+	}
+	c.risky.ReplyBytes = int64(reply.size)
+	c.risky.ReplyCount = reply.count
+	if c.risky.recorded || (c.risky.Reason == riskFullRead || c.risky.Reason == riskLargeRange) && reply.count >= riskyReplyElements {
+		recordRisky(c.risky, stat)
+	}
+}
+
+// riskyLatencyInfo 记录危险命令的耗时，DEL 耗时过长时确认
+func riskyLatencyInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	if c.risky == nil {
+		return
+	}
+	c.risky.Latency = execTime
+	if c.risky.recorded || c.risky.Reason == riskSlowDelete && execTime >= riskyDelTime {
+		recordRisky(c.risky, stat)
+	}
+}
+
+func aggregationRisky(stat *OverallStats, newStat *OverallStats) {
+	for key, value := range newStat.tmpRiskyReasons {
+		stat.tmpRiskyReasons[key] += value
+	}
+	for _, r := range newStat.tmpRiskyCalls {
+		call := *r
+		call.index = -1
+		keepRisky(&stat.tmpRiskyCalls, &call)
+	}
+}
+
+// analysisRisky 按耗时排序，耗时相同时按响应字节数排序
+func analysisRisky(stat *OverallStats, topNum int) {
+	stat.RiskyCommands = topKv(stat.tmpRiskyReasons, topNum)
+	stat.RiskyCalls = make([]*RiskyCall, len(stat.tmpRiskyCalls))
+	copy(stat.RiskyCalls, stat.tmpRiskyCalls)
+	sort.Slice(stat.RiskyCalls, func(i, j int) bool {
+		if stat.RiskyCalls[i].Latency != stat.RiskyCalls[j].Latency {
+			return stat.RiskyCalls[i].Latency > stat.RiskyCalls[j].Latency
+		}
+		return stat.RiskyCalls[i].ReplyBytes > stat.RiskyCalls[j].ReplyBytes
+	})
+	if len(stat.RiskyCalls) > topNum {
+		stat.RiskyCalls = stat.RiskyCalls[:topNum]
+	}
+}
@@ -0,0 +1,110 @@
+package hotkeys
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+var maxScripts = 1000 // 单线程最多记录的脚本数量，超过时替换调用最少的脚本
+
+// ScriptStats 一个 lua 脚本或函数的调用统计
+type ScriptStats struct {
+	Key      string        `json:"key"`       // 脚本的 SHA1，EVAL 按脚本内容计算；FCALL 为函数名
+	Body     string        `json:"body"`      // EVAL 中的脚本内容，超长截断
+	Calls    int64         `json:"calls"`     // 调用次数
+	Errors   int64         `json:"errors"`    // 错误响应次数
+	NoScript int64         `json:"no_script"` // 返回 NOSCRIPT 的次数
+	Latency  *LatencyStats `json:"latency"`   // 耗时分布
+	latency  *latencyHistogram
+}
+
+// scriptCommand 脚本按内容的 SHA1 统计，EVALSHA 为参数中的 SHA1，FCALL 为函数名
+func scriptCommand(c *redisCommand) {
+	args := c.args
+	if strings.HasPrefix(c.cmd, "FCALL") {
+		c.script = "function:" + args[1]
+	} else if strings.HasPrefix(c.cmd, "EVALSHA") {
+		c.script = strings.ToLower(args[1])
+	} else {
+		sum := sha1.Sum([]byte(args[1]))
+		c.script = hex.EncodeToString(sum[:])
+	}
+}
+
+func scriptStats(stat *OverallStats, key string) *ScriptStats {
+	s, ok := stat.tmpScripts[key]
+	if !ok {
+		s = &ScriptStats{Key: key, latency: newLatencyHistogram()}
+		stat.tmpScripts[key] = s
+	}
+	return s
+}
+
+// scriptInfo 统计脚本调用，脚本声明的 key 和其它命令一样按 key 统计
+func scriptInfo(c *redisCommand, stat *OverallStats) {
+	if c.script == "" {
+		return
+	}
+	if evicted, full := stat.scriptHitters.admit(c.script, 1); full {
+		delete(stat.tmpScripts, evicted)
+	}
+	s := scriptStats(stat, c.script)
+	s.Calls++
+	if s.Body == "" && strings.HasPrefix(c.cmd, "EVAL") && !strings.HasPrefix(c.cmd, "EVALSHA") {
+		s.Body = c.args[1]
+		if len(s.Body) > maxKeepLength {
+			s.Body = s.Body[:maxKeepLength]
+		}
+	}
+}
+
+// scriptReplyInfo 统计脚本的错误响应
+func scriptReplyInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	if c.script == "" {
+		return
+	}
+	s, ok := stat.tmpScripts[c.script]
+	if !ok {
+		return
+	}
+	s.Errors++
+	if reply.errPrefix() == "NOSCRIPT" {
+		s.NoScript++
+	}
+}
+
+// scriptLatencyInfo 记录脚本的耗时
+func scriptLatencyInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	if s, ok := stat.tmpScripts[c.script]; ok && c.script != "" {
+		s.latency.record(execTime)
+	}
+}
+
+func aggregationScript(stat *OverallStats, newStat *OverallStats) {
+	for key, value := range newStat.tmpScripts {
+		s := scriptStats(stat, key)
+		if s == nil {
+			continue
+		}
+		if s.Body == "" {
+			s.Body = value.Body
+		}
+		s.Calls += value.Calls
+		s.Errors += value.Errors
+		s.NoScript += value.NoScript
+		s.latency.merge(value.latency)
+	}
+}
+
+func analysisScript(stat *OverallStats, topNum int) {
+	for _, s := range stat.tmpScripts {
+		s.Latency = s.latency.summary(s.Key)
+		stat.TopScripts = append(stat.TopScripts, s)
+	}
+	sort.Slice(stat.TopScripts, func(i, j int) bool { return stat.TopScripts[i].Calls > stat.TopScripts[j].Calls })
+	if len(stat.TopScripts) > topNum {
+		stat.TopScripts = stat.TopScripts[:topNum]
+	}
+}
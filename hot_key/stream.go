@@ -0,0 +1,298 @@
+package hotkeys
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+var (
+	flushInterval   = 10 * time.Second // 按包时间定期刷新重组缓存
+	flushOlderThan  = 3 * time.Second  // 等待乱序包的最长时间
+	closeOlderThan  = 2 * time.Minute  // 空闲连接超时释放
+	maxPagesPerConn = 64               // 单连接最多缓存的乱序页
+	maxPagesTotal   = 65536            // 单线程最多缓存的乱序页
+	maxPending      = 10000            // 单连接等待响应的命令上限
+)
+
+// captureContext 实现 reassembly.AssemblerContext
+type captureContext gopacket.CaptureInfo
+
+func (c *captureContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// redisStreamFactory 为每个TCP连接创建一个 redisStream，一个分析线程一个实例
+type redisStreamFactory struct {
+	endpoints []*endpoint
+	cmdLen    int
+	cmdFile   *commandWriter
+	stats     []*OverallStats // 按 endpoint.index 保存每个 redis 实例的统计
+}
+
+func newAssembler(factory *redisStreamFactory) *reassembly.Assembler {
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+	assembler.MaxBufferedPagesPerConnection = maxPagesPerConn
+	assembler.MaxBufferedPagesTotal = maxPagesTotal
+	return assembler
+}
+
+func (f *redisStreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	s := &redisStream{
+		factory: f,
+		request: newRespReader(),
+		reply:   newRespReader(),
+	}
+	// 第一个包可能是响应包，按实例的地址和端口确定请求方向
+	ep, toServer := matchPacket(f.endpoints, netFlow, tcp)
+	if toServer {
+		s.requestDir = reassembly.TCPDirClientToServer
+		s.clientIp, s.serverIp = netFlow.Src().String(), netFlow.Dst().String()
+		s.clientPort, s.serverPort = tcp.SrcPort, tcp.DstPort
+		s.clientFamily = ipFamily(netFlow.Src())
+	} else {
+		s.requestDir = reassembly.TCPDirServerToClient
+		s.clientIp, s.serverIp = netFlow.Dst().String(), netFlow.Src().String()
+		s.clientPort, s.serverPort = tcp.DstPort, tcp.SrcPort
+		s.clientFamily = ipFamily(netFlow.Dst())
+	}
+	if ep != nil {
+		s.monitored = true
+		s.stat = f.stats[ep.index]
+	}
+	return s
+}
+
+// redisStream 一个客户端连接的双向数据流
+type redisStream struct {
+	factory      *redisStreamFactory
+	requestDir   reassembly.TCPFlowDirection
+	clientIp     string
+	clientPort   layers.TCPPort
+	clientFamily string
+	serverIp     string
+	serverPort   layers.TCPPort
+	monitored    bool
+	stat         *OverallStats // 连接所属实例的统计
+	request      *respReader
+	reply        *respReader
+	pending      []*redisCommand // 按发送顺序等待响应的命令
+	session      connSession     // 连接表中没有连接时的会话状态，例如代理模式
+	multi        bool            // 在 MULTI 和 EXEC/DISCARD 之间
+	queued       int64           // 当前事务中排队的命令数
+	replyTime    int64           // 当前响应第一个字节所在包的时间
+}
+
+func (s *redisStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	// 允许从连接中途开始抓包
+	*start = true
+	return true
+}
+
+func (s *redisStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, start, _, skip := sg.Info()
+	length, _ := sg.Lengths()
+	if !s.monitored {
+		return
+	}
+	if start {
+		s.request.resync = false
+		s.reply.resync = false
+	}
+	if skip != 0 {
+		// 存在丢包，丢弃不完整的数据，已发送的命令无法再匹配响应
+		log.Debugf("skip %d bytes %s:%s -> %s:%s", skip, s.clientIp, s.clientPort.String(), s.serverIp, s.serverPort.String())
+		if skip > 0 {
+			s.stat.DiscardPacketSum++
+		}
+		if dir == s.requestDir {
+			s.request.reset()
+		}
+		s.reply.reset()
+		s.pending = nil
+		s.replyTime = 0
+	}
+	if length == 0 {
+		return
+	}
+	if dir == s.requestDir {
+		s.readCommands(sg.Fetch(length), sg.CaptureInfo)
+		return
+	}
+	// 中途抓包，没有等待响应的命令时无法判断响应的起始位置，订阅连接从推送消息开始
+	data := sg.Fetch(length)
+	if !s.reply.resync || len(s.pending) > 0 || pushPrefix(data) {
+		s.readReplies(data, sg.CaptureInfo)
+	}
+}
+
+// readCommands 解析请求方向的数据，captureInfo 返回数据中第 offset 个字节所在包的信息
+func (s *redisStream) readCommands(data []byte, captureInfo func(offset int) gopacket.CaptureInfo) {
+	pending := s.request.buffered()
+	s.request.feed(data)
+	consumed := 0
+	for {
+		before := s.request.buffered()
+		args, err := s.request.readCommand()
+		if err == errIncomplete {
+			return
+		}
+		if err != nil {
+			log.Debugf("parse command fail %s:%s, err: %v", s.clientIp, s.clientPort.String(), err)
+			// 协议错误时丢弃了缓存，之后的位置按本次数据中剩余的字节计算
+			pending, consumed = 0, len(data)-s.request.buffered()
+			continue
+		}
+		size := before - s.request.buffered()
+		consumed += size
+		// 以命令最后一个字节所在包的时间作为请求时间
+		offset := consumed - pending - 1
+		if offset < 0 {
+			offset = 0
+		}
+		receiveTime := captureInfo(offset).Timestamp.UnixMicro()
+		c := newRedisCommand(args, s.factory.cmdLen, receiveTime)
+		c.size = size
+		s.recordCommand(c)
+	}
+}
+
+// readReplies 解析响应方向的数据，响应按命令发送顺序返回
+func (s *redisStream) readReplies(data []byte, captureInfo func(offset int) gopacket.CaptureInfo) {
+	length := len(data)
+	s.reply.feed(data)
+	for {
+		if s.replyTime == 0 && s.reply.buffered() > 0 {
+			// 新响应的第一个字节在本次数据中的位置
+			offset := length - s.reply.buffered()
+			if offset < 0 {
+				offset = 0
+			}
+			s.replyTime = captureInfo(offset).Timestamp.UnixMicro()
+		}
+		reply, err := s.reply.readReply()
+		if err == errIncomplete {
+			if s.reply.idle() {
+				s.replyTime = 0
+			}
+			return
+		}
+		replyTime := s.replyTime
+		s.replyTime = 0
+		if err != nil {
+			log.Debugf("parse reply fail %s:%s, err: %v", s.clientIp, s.clientPort.String(), err)
+			s.pending = nil
+			continue
+		}
+		if s.pushInfo(reply) {
+			continue
+		}
+		if len(s.pending) == 0 {
+			s.stat.UnmatchedReplySum++
+			continue
+		}
+		c := s.pending[0]
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.sessionReplyInfo(c, reply)
+		replyInfo(c, reply, s.stat)
+		if !c.ignore && replyTime >= c.receiveTime {
+			latencyInfo(c, replyTime-c.receiveTime, s.stat)
+		}
+	}
+}
+
+// connSession 连接的会话状态，抓包时保存在连接表中，重组流超时释放后重建不会丢失
+func (s *redisStream) connSession() *connSession {
+	if conn, ok := s.stat.conns[connKey(hostPort(s.clientIp, s.clientPort), hostPort(s.serverIp, s.serverPort))]; ok {
+		return &conn.session
+	}
+	return &s.session
+}
+
+// trackSession 跟踪连接上的 SELECT、AUTH、HELLO 和 CLIENT SETNAME
+// SETNAME 按请求生效，SELECT 的 db 和认证的用户在响应成功后生效
+func (s *redisStream) trackSession(c *redisCommand) {
+	session := s.connSession()
+	args := c.args
+	switch c.cmd {
+	case "SELECT":
+		if len(args) == 2 {
+			c.selectDb = args[1]
+		}
+	case "AUTH":
+		// AUTH password 为 default 用户
+		if len(args) == 3 {
+			c.authUser = args[1]
+		} else if len(args) == 2 {
+			c.authUser = "default"
+		}
+	case "HELLO":
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if i+2 < len(args) {
+					c.authUser = args[i+1]
+					i += 2
+				}
+			case "SETNAME":
+				if i+1 < len(args) {
+					session.name = args[i+1]
+					i++
+				}
+			}
+		}
+	case "CLIENT":
+		if len(args) == 3 && strings.ToUpper(args[1]) == "SETNAME" {
+			session.name = args[2]
+		}
+	}
+	c.db, c.user, c.name = session.db, session.user, session.name
+}
+
+// sessionReplyInfo SELECT 和认证成功后修改连接的 db 和用户，失败不影响之前的状态
+func (s *redisStream) sessionReplyInfo(c *redisCommand, reply *respValue) {
+	if (c.authUser == "" && c.selectDb == "") || reply.kind == '-' || reply.kind == '!' {
+		return
+	}
+	session := s.connSession()
+	if c.authUser != "" {
+		session.user = c.authUser
+	}
+	if c.selectDb != "" {
+		session.db = c.selectDb
+	}
+}
+
+func (s *redisStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+// recordCommand 统计一条完整命令
+func (s *redisStream) recordCommand(c *redisCommand) {
+	if len(s.pending) >= maxPending {
+		// 长时间没有响应，无法确定之后的响应对应哪条命令，和丢包一样丢弃并重新对齐
+		log.Debugf("too many pending commands %s:%s", s.clientIp, s.clientPort.String())
+		s.stat.DiscardPacketSum++
+		s.reply.reset()
+		s.pending = nil
+		s.replyTime = 0
+	}
+	c.clientIp = s.clientIp
+	if !s.trackSubscribe(c) {
+		s.pending = append(s.pending, c)
+	}
+	s.trackSession(c)
+	s.trackTransaction(c)
+	pipelineInfo(s.clientIp, len(s.pending), s.stat)
+	if c.ignore {
+		return
+	}
+	src := hostPort(s.clientIp, s.clientPort)
+	dst := hostPort(s.serverIp, s.serverPort)
+	connectionCommand(s.stat, src, dst)
+	commandInfo(c, s.clientIp, s.clientFamily, src, dst, s.stat, s.factory.cmdFile)
+}
@@ -0,0 +1,1180 @@
+package hotkeys
+
+import (
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testConn 构造一个客户端到 redis 的TCP连接
+type testConn struct {
+	client, server         net.IP
+	clientPort, serverPort uint16
+	clientSeq, serverSeq   uint32
+}
+
+func newTestConn(client, server string, clientPort, serverPort uint16) *testConn {
+	return &testConn{
+		client:     net.ParseIP(client),
+		server:     net.ParseIP(server),
+		clientPort: clientPort,
+		serverPort: serverPort,
+		clientSeq:  1000,
+		serverSeq:  5000,
+	}
+}
+
+// packet 生成一个带负载的数据包，fromClient 为 true 时是请求方向
+func (c *testConn) packet(t *testing.T, fromClient bool, payload string, ts time.Time) *NetPacket {
+	tcp := &layers.TCP{PSH: true, ACK: true, Window: 65535}
+	srcIp, dstIp := c.server, c.client
+	tcp.SrcPort, tcp.DstPort = layers.TCPPort(c.serverPort), layers.TCPPort(c.clientPort)
+	tcp.Seq, tcp.Ack = c.serverSeq, c.clientSeq
+	if fromClient {
+		srcIp, dstIp = c.client, c.server
+		tcp.SrcPort, tcp.DstPort = layers.TCPPort(c.clientPort), layers.TCPPort(c.serverPort)
+		tcp.Seq, tcp.Ack = c.clientSeq, c.serverSeq
+		c.clientSeq += uint32(len(payload))
+	} else {
+		c.serverSeq += uint32(len(payload))
+	}
+	return buildPacket(t, srcIp, dstIp, tcp, []byte(payload), ts)
+}
+
+// control 生成一个不带负载的控制包，SYN 和 FIN 占用一个序号
+func (c *testConn) control(t *testing.T, fromClient bool, tcp *layers.TCP, ts time.Time) *NetPacket {
+	tcp.Window = 65535
+	srcIp, dstIp := c.server, c.client
+	tcp.SrcPort, tcp.DstPort = layers.TCPPort(c.serverPort), layers.TCPPort(c.clientPort)
+	seq := &c.serverSeq
+	tcp.Seq, tcp.Ack = c.serverSeq, c.clientSeq
+	if fromClient {
+		srcIp, dstIp = c.client, c.server
+		tcp.SrcPort, tcp.DstPort = layers.TCPPort(c.clientPort), layers.TCPPort(c.serverPort)
+		seq = &c.clientSeq
+		tcp.Seq, tcp.Ack = c.clientSeq, c.serverSeq
+	}
+	if tcp.SYN || tcp.FIN {
+		*seq++
+	}
+	return buildPacket(t, srcIp, dstIp, tcp, nil, ts)
+}
+
+func buildPacket(t *testing.T, srcIp, dstIp net.IP, tcp *layers.TCP, payload []byte, ts time.Time) *NetPacket {
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6},
+	}
+	var network gopacket.SerializableLayer
+	if srcIp.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: srcIp.To4(), DstIP: dstIp.To4()}
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+		network = ip
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: srcIp, DstIP: dstIp}
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+		network = ip
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, network, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = ts
+	packet.Metadata().CaptureLength = len(buf.Bytes())
+	packet.Metadata().Length = len(buf.Bytes())
+	return &NetPacket{PacketContent: packet}
+}
+
+// command 编码一条 RESP 命令
+func command(args ...string) string {
+	s := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		s += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return s
+}
+
+// testWorker 一个分析线程，按 endpoints 统计每个实例
+type testWorker struct {
+	eps       []*endpoint
+	stats     []*OverallStats
+	assembler *reassembly.Assembler
+}
+
+func newTestWorker(t *testing.T, endpoints ...string) *testWorker {
+	eps, err := parseEndpoints(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newEndpointWorker(eps)
+}
+
+func newEndpointWorker(eps []*endpoint) *testWorker {
+	w := &testWorker{eps: eps}
+	for range eps {
+		w.stats = append(w.stats, newOverallStats())
+	}
+	w.assembler = newAssembler(&redisStreamFactory{endpoints: eps, cmdLen: 100, stats: w.stats})
+	return w
+}
+
+// feed 按顺序处理数据包，最后刷新重组缓存
+func (w *testWorker) feed(packets ...*NetPacket) {
+	for _, packet := range packets {
+		PacketInfo(packet, w.eps, w.stats, w.assembler)
+	}
+	w.assembler.FlushAll()
+}
+
+func TestPipelineLatency(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	at := func(us int) time.Time { return start.Add(time.Duration(us) * time.Microsecond) }
+
+	packets := []*NetPacket{
+		// 三条 pipeline 命令，第三条跨两个包
+		conn.packet(t, true, "*2\r\n$3\r\nGET\r\n$2\r\nk1\r\n*2\r\n$3\r\nGET\r\n$2\r\nk2\r\n*2\r\n$3\r\nGET", at(0)),
+		conn.packet(t, true, "\r\n$2\r\nk3\r\n", at(50)),
+		// 响应分两个包返回，第二个响应跨包
+		conn.packet(t, false, "$2\r\nv1\r\n$2\r\n", at(200)),
+		conn.packet(t, false, "v2\r\n$-1\r\n", at(400)),
+	}
+	w.feed(packets...)
+
+	if stat.TotalAccessSum != 3 {
+		t.Fatalf("got %d commands, want 3", stat.TotalAccessSum)
+	}
+	sort.Slice(stat.SlowestCalls, func(i, j int) bool { return stat.SlowestCalls[i].Key < stat.SlowestCalls[j].Key })
+	want := []int64{200, 200, 350}
+	if len(stat.SlowestCalls) != len(want) {
+		t.Fatalf("got %d latencies, want %d", len(stat.SlowestCalls), len(want))
+	}
+	for i, kv := range stat.SlowestCalls {
+		if kv.Value != want[i] {
+			t.Fatalf("%s latency %d, want %d", kv.Key, kv.Value, want[i])
+		}
+	}
+	if stat.UnmatchedReplySum != 0 {
+		t.Fatalf("got %d unmatched replies", stat.UnmatchedReplySum)
+	}
+
+	// 请求和响应按编码后的字节数统计
+	if stat.TotalRequestBytes != 3*21 || stat.TotalReplyBytes != 8+8+5 {
+		t.Fatalf("got request %d reply %d bytes", stat.TotalRequestBytes, stat.TotalReplyBytes)
+	}
+	if len(stat.CommandReplyBytes) != 1 || stat.CommandReplyBytes[0].Value != 21 {
+		t.Fatalf("got command reply bytes %v", stat.CommandReplyBytes)
+	}
+}
+
+func TestPendingOverflow(t *testing.T) {
+	defer func(n int) { maxPending = n }(maxPending)
+	maxPending = 2
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+
+	// 第三条命令超过上限，之前的命令不再匹配响应，之后的响应重新对齐
+	w.feed(
+		conn.packet(t, true, command("GET", "aaa")+command("GET", "bbb")+command("GET", "ccc"), start),
+		conn.packet(t, false, "$-1\r\n", start.Add(time.Millisecond)),
+	)
+	if stat.DiscardPacketSum != 1 {
+		t.Fatalf("got %d discards, want 1", stat.DiscardPacketSum)
+	}
+	if _, ok := stat.tmpMissPrefixes["ccc"]; !ok || len(stat.tmpMissPrefixes) != 1 {
+		t.Fatalf("got miss prefixes %v, want only ccc", stat.tmpMissPrefixes)
+	}
+}
+
+func TestPayloadBytes(t *testing.T) {
+	stat := newOverallStats()
+	for _, size := range []int{100, 300, 20} {
+		c := newRedisCommand([]string{"GET", "user:1"}, 100, 0)
+		c.size = 10
+		requestBytesInfo(c, stat)
+		replyBytesInfo(c, &respValue{size: size}, stat)
+	}
+	c := newRedisCommand([]string{"SET", "user:2", "value"}, 100, 0)
+	c.size = 1000
+	requestBytesInfo(c, stat)
+	replyBytesInfo(c, &respValue{size: 5}, stat)
+	analysisPayload(stat, 10)
+
+	if kv := stat.TopTransferKeys[0]; kv.Key != "SET user:2" || kv.Value != 1005 {
+		t.Fatalf("got top transfer %s %d", kv.Key, kv.Value)
+	}
+	if kv := stat.TopAvgReplyKeys[0]; kv.Key != "GET user:1" || kv.Value != 140 {
+		t.Fatalf("got top avg reply %s %d", kv.Key, kv.Value)
+	}
+	if stat.TotalRequestBytes != 1030 || stat.TotalReplyBytes != 425 {
+		t.Fatalf("got request %d reply %d bytes", stat.TotalRequestBytes, stat.TotalReplyBytes)
+	}
+}
+
+func TestWindowSnapshots(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(sec float64) time.Time { return start.Add(time.Duration(sec * float64(time.Second))) }
+	get := func(key string) string {
+		return "*2\r\n$3\r\nGET\r\n$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n"
+	}
+
+	// 两个线程各处理一个连接，聚合后按窗口合并
+	overall := newOverallStats()
+	overall.windowMerger = newWindowMerger((10 * time.Second).Microseconds(), 10)
+	var workers []*OverallStats
+	var threads []*testWorker
+	for range []string{"10.0.0.2", "10.0.0.3"} {
+		w := newTestWorker(t, "10.0.0.1:6379")
+		w.stats[0].windows = overall.windowMerger.counter()
+		// 分发协程开始时给每个线程发送时钟
+		advanceWindows(w.stats[0], at(0).UnixMicro())
+		threads = append(threads, w)
+		workers = append(workers, w.stats[0])
+	}
+	for i, client := range []string{"10.0.0.2", "10.0.0.3"} {
+		conn := newTestConn(client, "10.0.0.1", 40000, 6379)
+		threads[i].feed(
+			conn.packet(t, true, get("user:1"), at(1)),
+			conn.packet(t, true, get("sale:flash")+get("sale:flash"), at(12)),
+			conn.packet(t, true, get("user:1"), at(35)),
+		)
+	}
+	// 两个线程都进入 30 秒的窗口后，之前的窗口在聚合之前已经结束
+	if len(overall.windowMerger.closed) != 2 {
+		t.Fatalf("got %d closed windows before aggregation, want 2", len(overall.windowMerger.closed))
+	}
+	aggregation(overall, workers)
+	analysisWindow(overall, 10)
+
+	if len(overall.Windows) != 3 {
+		t.Fatalf("got %d windows, want 3", len(overall.Windows))
+	}
+	want := []struct {
+		start int64
+		key   string
+		sum   int64
+	}{
+		{at(0).UnixMicro(), "GET user:1", 2},
+		{at(10).UnixMicro(), "GET sale:flash", 4},
+		{at(30).UnixMicro(), "GET user:1", 2},
+	}
+	for i, w := range overall.Windows {
+		if w.StartTime != want[i].start || w.TotalAccessSum != want[i].sum {
+			t.Fatalf("window %d start %d sum %d, want %d %d", i, w.StartTime, w.TotalAccessSum, want[i].start, want[i].sum)
+		}
+		if len(w.TopKeys) != 1 || w.TopKeys[0].Key != want[i].key || w.TopKeys[0].Value != want[i].sum {
+			t.Fatalf("window %d top keys %v", i, w.TopKeys)
+		}
+	}
+	if overall.Windows[1].CommandsSec != 0.4 {
+		t.Fatalf("got %v commands/sec, want 0.4", overall.Windows[1].CommandsSec)
+	}
+}
+
+func TestWindowMerge(t *testing.T) {
+	m := newWindowMerger(1000, 1)
+	a, b := newOverallStats(), newOverallStats()
+	a.windows, b.windows = m.counter(), m.counter()
+	advanceWindows(a, 0)
+	advanceWindows(b, 0)
+	get := func(key string, at int64) *redisCommand { return newRedisCommand([]string{"GET", key}, 100, at) }
+	// 每个线程中 key:0 都不是最多的，合并后最多
+	for _, key := range []string{"key:0", "key:1", "key:1"} {
+		windowInfo(get(key, 10), nil, a)
+	}
+	for _, key := range []string{"key:0", "key:2", "key:2", "key:0"} {
+		windowInfo(get(key, 20), nil, b)
+	}
+	// 一个线程进入两个窗口之后，另一个线程还可能有数据，窗口不结束
+	windowInfo(get("key:3", 2500), nil, a)
+	if len(m.closed) != 0 {
+		t.Fatalf("got %d closed windows, want 0", len(m.closed))
+	}
+	// 空闲的线程收到时钟后窗口结束，不需要等到监控结束
+	advanceWindows(b, 2600)
+	w, ok := m.closed[0]
+	if !ok || w.TotalAccessSum != 7 || len(w.TopKeys) != 1 || w.TopKeys[0].Key != "GET key:0" || w.TopKeys[0].Value != 3 {
+		t.Fatalf("got closed windows %v", m.closed)
+	}
+	// 结束后迟到的命令只累加访问次数
+	windowInfo(get("key:1", 30), nil, b)
+	stat := newOverallStats()
+	stat.windowMerger = m
+	aggregation(stat, []*OverallStats{a, b})
+	analysisWindow(stat, 1)
+	if len(stat.Windows) != 2 || stat.Windows[0].TotalAccessSum != 8 || stat.Windows[1].StartTime != 2000 {
+		t.Fatalf("got windows %+v", stat.Windows)
+	}
+
+	// 多个实例时实例合并后的窗口再交给整体合并
+	all, x, y := newWindowMerger(1000, 1), newWindowMerger(1000, 1), newWindowMerger(1000, 1)
+	x.parent, y.parent = all, all
+	cx, cy := newOverallStats(), newOverallStats()
+	cx.windows, cy.windows = x.counter(), y.counter()
+	advanceWindows(cx, 0)
+	advanceWindows(cy, 0)
+	windowInfo(get("key:0", 10), nil, cx)
+	windowInfo(get("key:0", 20), nil, cy)
+	windowInfo(get("key:1", 30), nil, cy)
+	windowInfo(get("key:1", 2500), nil, cx)
+	// 实例 x 的线程都已进入两个窗口之后，整体还要等待实例 y
+	if len(x.closed) != 1 || len(all.closed) != 0 {
+		t.Fatalf("got closed windows %v %v", x.closed, all.closed)
+	}
+	advanceWindows(cy, 2600)
+	if w, ok := all.closed[0]; !ok || w.TotalAccessSum != 3 || w.TopKeys[0].Key != "GET key:0" || w.TopKeys[0].Value != 2 {
+		t.Fatalf("got overall closed windows %v", all.closed)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	// 桶的边界连续，相对误差不超过 1/64
+	for _, v := range []int64{0, 63, 64, 127, 128, 1000, 123456, 1 << 40} {
+		idx := latencyBucket(v)
+		if max := latencyBucketMax(idx); max < v || float64(max-v) > float64(v)/64 {
+			t.Fatalf("value %d bucket %d max %d", v, idx, max)
+		}
+		if idx > 0 && latencyBucketMax(idx-1) >= v {
+			t.Fatalf("value %d also fits bucket %d", v, idx-1)
+		}
+	}
+	// 两个线程合并后计算分位值
+	a, b := newLatencyHistogram(), newLatencyHistogram()
+	for i := int64(1); i <= 10000; i++ {
+		if i%2 == 0 {
+			a.record(i)
+		} else {
+			b.record(i)
+		}
+	}
+	a.merge(b)
+	s := a.summary("GET")
+	want := map[string][2]int64{"p50": {s.P50, 5000}, "p90": {s.P90, 9000}, "p99": {s.P99, 9900}, "p999": {s.P999, 9990}}
+	for name, v := range want {
+		if float64(v[0]-v[1]) > float64(v[1])/64 || v[0] < v[1] {
+			t.Errorf("%s got %d, want about %d", name, v[0], v[1])
+		}
+	}
+	if s.Count != 10000 || s.Max != 10000 {
+		t.Fatalf("got count %d max %d", s.Count, s.Max)
+	}
+}
+
+func TestPrefixLatencyEviction(t *testing.T) {
+	defer func(n int) { maxLatencyPrefix = n }(maxLatencyPrefix)
+	maxLatencyPrefix = 2
+	stat := newOverallStats()
+	get := func(key string) *redisCommand { return newRedisCommand([]string{"GET", key}, 100, 0) }
+	for i := 0; i < 10; i++ {
+		latencyHistogramInfo(get("cold"+strconv.Itoa(i)), 10, stat)
+	}
+	// 后出现的热点前缀替换请求数最少的前缀，之后的冷门前缀不会替换它
+	for i := 0; i < 5; i++ {
+		latencyHistogramInfo(get("hot"), 10, stat)
+	}
+	for i := 10; i < 14; i++ {
+		latencyHistogramInfo(get("cold"+strconv.Itoa(i)), 10, stat)
+	}
+	if len(stat.tmpPrefixLatency) != 2 {
+		t.Fatalf("got %d prefix histograms, want 2", len(stat.tmpPrefixLatency))
+	}
+	if h, ok := stat.tmpPrefixLatency["hot"]; !ok || h.total != 5 {
+		t.Fatalf("got hot prefix histogram %+v", h)
+	}
+	if _, ok := stat.tmpPrefixLatency["cold13"]; !ok {
+		t.Fatalf("got prefix histograms %v", stat.tmpPrefixLatency)
+	}
+}
+
+func TestLateHotEntries(t *testing.T) {
+	defer func(classes, scripts, channels, groups, blocking int) {
+		maxClassPrefixes, maxScripts, maxChannels, maxGroups, maxBlockingKeys = classes, scripts, channels, groups, blocking
+	}(maxClassPrefixes, maxScripts, maxChannels, maxGroups, maxBlockingKeys)
+	maxClassPrefixes, maxScripts, maxChannels, maxGroups, maxBlockingKeys = 2, 2, 2, 2, 2
+	stat := newOverallStats()
+	access := func(name string) {
+		get := newRedisCommand([]string{"GET", name}, 100, 0)
+		get.db, get.name = name, name
+		classInfo(get, []string{name}, stat)
+		groupInfo(get, stat)
+		script := newRedisCommand([]string{"EVALSHA", name, "0"}, 100, 0)
+		scriptInfo(script, stat)
+		publishInfo(newRedisCommand([]string{"PUBLISH", name, "m"}, 100, 0), stat)
+		blpop := newRedisCommand([]string{"BLPOP", name, "0"}, 100, 0)
+		blockingReplyInfo(blpop, &respValue{kind: '*', elems: []*respValue{{kind: '$', str: name}, {kind: '$', str: "v"}}}, stat)
+	}
+	for i := 0; i < 10; i++ {
+		access("cold" + strconv.Itoa(i))
+	}
+	// 表满之后出现的热点替换访问最少的条目，之后的冷门条目不会替换它
+	for i := 0; i < 5; i++ {
+		access("hot")
+	}
+	for i := 10; i < 14; i++ {
+		access("cold" + strconv.Itoa(i))
+	}
+	if s, ok := stat.tmpPrefixClasses["hot"]; !ok || s.Total != 5 || len(stat.tmpPrefixClasses) != 2 {
+		t.Fatalf("got prefix classes %v", stat.tmpPrefixClasses)
+	}
+	if s, ok := stat.tmpScripts["hot"]; !ok || s.Calls != 5 || len(stat.tmpScripts) != 2 {
+		t.Fatalf("got scripts %v", stat.tmpScripts)
+	}
+	if s, ok := stat.tmpChannels["hot"]; !ok || s.Publishes != 5 || len(stat.tmpChannels) != 2 {
+		t.Fatalf("got channels %v", stat.tmpChannels)
+	}
+	if g, ok := stat.tmpDbStats["hot"]; !ok || g.Calls != 5 || len(stat.tmpDbStats) != 2 {
+		t.Fatalf("got db groups %v", stat.tmpDbStats)
+	}
+	if g, ok := stat.tmpNameStats["hot"]; !ok || g.Calls != 5 || len(stat.tmpNameStats) != 2 {
+		t.Fatalf("got name groups %v", stat.tmpNameStats)
+	}
+	if s, ok := stat.tmpBlocking["BLPOP hot"]; !ok || s.Served != 5 || len(stat.tmpBlocking) != 2 {
+		t.Fatalf("got blocking keys %v", stat.tmpBlocking)
+	}
+}
+
+func TestReplyStats(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	moved := "-MOVED 3999 10.0.0.3:6379\r\n"
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	replies := "$3\r\nbob\r\n$-1\r\n" + moved + wrongType + "$-1\r\n"
+	// 响应分两个包返回，WRONGTYPE 错误跨包
+	split := len(replies) - len(wrongType[20:]) - len("$-1\r\n")
+	w.feed(
+		conn.packet(t, true, command("GET", "user:1")+command("GET", "user:2")+command("GET", "order:1")+
+			command("LPUSH", "user:1", "x")+command("GET", "user:3"), start),
+		conn.packet(t, false, replies[:split], start.Add(time.Millisecond)),
+		conn.packet(t, false, replies[split:], start.Add(2*time.Millisecond)),
+	)
+	analysisReply(stat, 10)
+	analysisPayload(stat, 10)
+
+	if stat.TotalErrorSum != 2 || len(stat.ErrorCommands) != 2 {
+		t.Fatalf("got %d errors %+v", stat.TotalErrorSum, stat.ErrorCommands)
+	}
+	for _, key := range []string{"GET MOVED", "LPUSH WRONGTYPE"} {
+		if !foundKv(stat.ErrorCommands, key) {
+			t.Fatalf("got error commands %+v, want %s", stat.ErrorCommands, key)
+		}
+	}
+	// 错误响应不计入未命中率
+	if len(stat.MissPrefixes) != 1 {
+		t.Fatalf("got miss prefixes %+v", stat.MissPrefixes)
+	}
+	if r := stat.MissPrefixes[0]; r.Key != "user" || r.Total != 3 || r.Miss != 2 || r.Ratio != 0.667 {
+		t.Fatalf("got miss ratio %+v", r)
+	}
+	if stat.TotalReplyBytes != int64(len(replies)) {
+		t.Fatalf("got reply bytes %d, want %d", stat.TotalReplyBytes, len(replies))
+	}
+	// 按响应字节数排序，WRONGTYPE 错误比四个 GET 响应长
+	got := stat.CommandReplyBytes
+	if len(got) != 2 {
+		t.Fatalf("got %d command reply bytes, want 2", len(got))
+	}
+	if got[0].Key != "LPUSH" || got[0].Value != int64(len(wrongType)) || got[1].Key != "GET" || got[1].Value != int64(len(replies)-len(wrongType)) {
+		t.Fatalf("got command reply bytes %s %d, %s %d", got[0].Key, got[0].Value, got[1].Key, got[1].Value)
+	}
+}
+
+func TestClusterRedirects(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	get := "*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"
+	w.feed(
+		conn.packet(t, true, get+get+get+get, start),
+		conn.packet(t, false, "-MOVED 10778 10.0.0.3:6379\r\n-ASK 10778 10.0.0.3:6379\r\n$1\r\nv\r\n$1\r\nv\r\n", start.Add(time.Millisecond)),
+	)
+	stat.MonitorStartTime, stat.MonitorEndTime = start.UnixMicro(), start.Add(2*time.Second).UnixMicro()
+	analysisCluster(stat, 10)
+
+	if stat.TotalMovedSum != 1 || stat.TotalAskSum != 1 {
+		t.Fatalf("got moved %d ask %d", stat.TotalMovedSum, stat.TotalAskSum)
+	}
+	if len(stat.HotSlots) != 1 {
+		t.Fatalf("got %d slots, want 1", len(stat.HotSlots))
+	}
+	if s := stat.HotSlots[0]; s.Slot != 10778 || s.Calls != 4 || s.Qps != 2 || s.Moved != 1 || s.Ask != 1 {
+		t.Fatalf("got slot %+v", s)
+	}
+	if len(stat.ClientRedirects) != 1 || stat.ClientRedirects[0].Key != "10.0.0.2" || stat.ClientRedirects[0].Ratio != 0.5 {
+		t.Fatalf("got client redirects %+v", stat.ClientRedirects[0])
+	}
+}
+
+func TestConnectionLifecycle(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	get := "*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"
+	// 短连接：建立、一条命令、关闭
+	short := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	// 长连接：空闲 90 秒后再次访问，一直未关闭
+	idle := newTestConn("10.0.0.2", "10.0.0.1", 40001, 6379)
+	// 中途抓到的连接，被 RST 关闭
+	reset := newTestConn("10.0.0.3", "10.0.0.1", 40002, 6379)
+	w.feed(
+		short.control(t, true, &layers.TCP{SYN: true}, start),
+		short.control(t, false, &layers.TCP{SYN: true, ACK: true}, start),
+		idle.control(t, true, &layers.TCP{SYN: true}, start),
+		idle.control(t, false, &layers.TCP{SYN: true, ACK: true}, start),
+		short.packet(t, true, get, start.Add(100*time.Microsecond)),
+		short.packet(t, false, "$-1\r\n", start.Add(200*time.Microsecond)),
+		idle.packet(t, true, get, start.Add(time.Millisecond)),
+		reset.packet(t, true, get, start.Add(time.Millisecond)),
+		short.control(t, true, &layers.TCP{FIN: true, ACK: true}, start.Add(500*time.Millisecond)),
+		short.control(t, false, &layers.TCP{FIN: true, ACK: true}, start.Add(501*time.Millisecond)),
+		reset.control(t, false, &layers.TCP{RST: true}, start.Add(2*time.Second)),
+		idle.packet(t, true, get, start.Add(90*time.Second)),
+	)
+	if stat.ActiveProcessed != 3 || stat.NewConnectNum != 2 || stat.CloseConnectNum != 2 {
+		t.Fatalf("got active %d new %d close %d", stat.ActiveProcessed, stat.NewConnectNum, stat.CloseConnectNum)
+	}
+	// 已关闭的连接被淘汰，空闲未超时的连接保留
+	evictConnections(stat, start.Add(95*time.Second).UnixMicro())
+	if len(stat.conns) != 1 {
+		t.Fatalf("got %d connections after evict, want 1", len(stat.conns))
+	}
+	stat.MonitorStartTime, stat.MonitorEndTime = start.UnixMicro(), start.Add(100*time.Second).UnixMicro()
+	finishConnections(stat, stat.MonitorEndTime)
+	analysisConnection(stat, 10)
+
+	if stat.ShortConnectionSum != 1 || len(stat.ShortConnections) != 1 {
+		t.Fatalf("got %d short connections", stat.ShortConnectionSum)
+	}
+	if c := stat.ShortConnections[0]; c.Key != "10.0.0.2:40000 -> 10.0.0.1:6379" || c.Commands != 1 || c.Duration != 500*1000 {
+		t.Fatalf("got short connection %+v", c)
+	}
+	if len(stat.IdleConnections) != 1 || stat.IdleConnections[0].MaxIdle != (90*time.Second-time.Millisecond).Microseconds() {
+		t.Fatalf("got idle connections %+v", stat.IdleConnections)
+	}
+	if c := stat.IdleConnections[0]; c.Commands != 2 || c.CloseTime != 0 {
+		t.Fatalf("got idle connection %+v", c)
+	}
+	if len(stat.ClientChurn) != 2 {
+		t.Fatalf("got %d churn clients, want 2", len(stat.ClientChurn))
+	}
+	if c := stat.ClientChurn[0]; c.Key != "10.0.0.2" || c.Opened != 2 || c.Closed != 1 || c.ShortLived != 1 || c.Rate != 0.02 {
+		t.Fatalf("got churn %+v", c)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	get := "*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	key := "10.0.0.2:40000 -> 10.0.0.1:6379"
+	w.feed(
+		conn.control(t, true, &layers.TCP{SYN: true}, at(0)),
+		conn.control(t, false, &layers.TCP{SYN: true, ACK: true}, at(1)),
+		conn.packet(t, true, get, at(2)),
+		conn.packet(t, false, "$-1\r\n", at(3)),
+		conn.control(t, true, &layers.TCP{FIN: true, ACK: true}, at(100)),
+		conn.control(t, false, &layers.TCP{FIN: true, ACK: true}, at(101)),
+	)
+	// 关闭后保留一段时间，迟到的 ACK 不会重建连接
+	evictConnections(stat, at(5000).UnixMicro())
+	w.feed(conn.control(t, true, &layers.TCP{ACK: true}, at(6000)))
+	if len(stat.conns) != 1 || stat.ActiveProcessed != 1 || stat.CloseConnectNum != 1 {
+		t.Fatalf("got %d connections active %d close %d", len(stat.conns), stat.ActiveProcessed, stat.CloseConnectNum)
+	}
+	// 保留期内同一个四元组的新 SYN 为端口复用，旧连接结束，重传的 SYN 不是新连接
+	w.feed(conn.control(t, true, &layers.TCP{SYN: true}, at(7000)))
+	conn.clientSeq--
+	w.feed(conn.control(t, true, &layers.TCP{SYN: true}, at(8000)))
+	if stat.ActiveProcessed != 2 || stat.NewConnectNum != 2 || stat.ShortConnectionSum != 1 {
+		t.Fatalf("got active %d new %d short %d", stat.ActiveProcessed, stat.NewConnectNum, stat.ShortConnectionSum)
+	}
+	if c := stat.conns[key]; c == nil || c.closed || c.OpenTime != at(7000).UnixMicro() || c.Commands != 0 {
+		t.Fatalf("got reused connection %+v", c)
+	}
+	// 中途抓到的连接没有关闭就收到新的 SYN，旧连接以最后一个包的时间关闭
+	other := newTestConn("10.0.0.3", "10.0.0.1", 40001, 6379)
+	w.feed(
+		other.packet(t, true, get, at(9000)),
+		other.control(t, true, &layers.TCP{SYN: true}, at(20000)),
+	)
+	if stat.ActiveProcessed != 4 || stat.NewConnectNum != 3 || stat.CloseConnectNum != 2 {
+		t.Fatalf("got active %d new %d close %d", stat.ActiveProcessed, stat.NewConnectNum, stat.CloseConnectNum)
+	}
+	// 超过保留时间后淘汰
+	evictConnections(stat, at(40000).UnixMicro())
+	if len(stat.conns) != 2 {
+		t.Fatalf("got %d connections after evict, want 2", len(stat.conns))
+	}
+}
+
+func TestSessionTracking(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	// 中途抓到的连接，db 未知
+	other := newTestConn("10.0.0.3", "10.0.0.1", 40001, 6379)
+	w.feed(
+		conn.control(t, true, &layers.TCP{SYN: true}, start),
+		conn.control(t, false, &layers.TCP{SYN: true, ACK: true}, start),
+		conn.packet(t, true, "*3\r\n$6\r\nCLIENT\r\n$7\r\nSETNAME\r\n$9\r\norder-svc\r\n*2\r\n$6\r\nSELECT\r\n$1\r\n2\r\n", start),
+		conn.packet(t, false, "+OK\r\n+OK\r\n", start.Add(100*time.Microsecond)),
+		conn.packet(t, true, command("GET", "user:1"), start.Add(200*time.Microsecond)),
+		conn.packet(t, false, "$-1\r\n", start.Add(300*time.Microsecond)),
+		// SELECT 失败不切换 db
+		conn.packet(t, true, command("SELECT", "99"), start.Add(400*time.Microsecond)),
+		conn.packet(t, false, "-ERR DB index is out of range\r\n", start.Add(500*time.Microsecond)),
+		conn.packet(t, true, command("GET", "user:1"), start.Add(600*time.Microsecond)),
+		conn.packet(t, false, "$-1\r\n", start.Add(700*time.Microsecond)),
+		// 认证失败不切换用户，认证成功后的命令按用户统计
+		other.packet(t, true, command("AUTH", "mallory", "bad"), start),
+		other.packet(t, false, "-WRONGPASS invalid username-password pair\r\n", start.Add(50*time.Microsecond)),
+		other.packet(t, true, command("AUTH", "alice", "pw"), start.Add(100*time.Microsecond)),
+		other.packet(t, false, "+OK\r\n", start.Add(150*time.Microsecond)),
+		other.packet(t, true, command("GET", "user:2"), start.Add(200*time.Microsecond)),
+		other.packet(t, false, "$1\r\nv\r\n", start.Add(250*time.Microsecond)),
+	)
+	analysisGroup(stat, 10)
+
+	if len(stat.DbStats) != 2 {
+		t.Fatalf("got %d dbs, want 2", len(stat.DbStats))
+	}
+	// SELECT 在响应成功之前仍属于之前的 db
+	if g := stat.DbStats[0]; g.Key != "2" || g.Calls != 3 || g.Latency.Count != 3 || g.TopKeys[0].Key != "GET user:1" || g.TopKeys[0].Value != 2 {
+		t.Fatalf("got db %+v", g)
+	}
+	if g := stat.DbStats[1]; g.Key != "0" || g.Calls != 2 {
+		t.Fatalf("got db %+v", g)
+	}
+	if len(stat.ClientNameStats) != 1 || stat.ClientNameStats[0].Key != "order-svc" || stat.ClientNameStats[0].Calls != 5 {
+		t.Fatalf("got client names %+v", stat.ClientNameStats)
+	}
+	if len(stat.UserCall) != 1 || stat.UserCall[0].Key != "alice" || stat.UserCall[0].Value != 1 {
+		t.Fatalf("got users %+v", stat.UserCall)
+	}
+}
+
+func TestSessionAfterIdle(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	w.feed(
+		conn.control(t, true, &layers.TCP{SYN: true}, start),
+		conn.control(t, false, &layers.TCP{SYN: true, ACK: true}, start),
+		conn.packet(t, true, command("SELECT", "5"), start),
+		conn.packet(t, false, "+OK\r\n", start.Add(100*time.Microsecond)),
+	)
+	// 重组流空闲释放后重建，连接表中的 db 仍然有效
+	w.feed(
+		conn.packet(t, true, command("GET", "user:1"), start.Add(3*time.Minute)),
+		conn.packet(t, false, "$1\r\nv\r\n", start.Add(3*time.Minute+100*time.Microsecond)),
+	)
+	if g, ok := stat.tmpDbStats["5"]; !ok || g.Calls != 1 {
+		t.Fatalf("got db stats %+v", stat.tmpDbStats)
+	}
+	// 只有 SELECT 本身属于 db 0
+	if g := stat.tmpDbStats["0"]; g.Calls != 1 {
+		t.Fatalf("idle connection reported under db 0")
+	}
+	// 连接空闲超时从连接表中淘汰后恢复活跃，db 仍然有效
+	later := start.Add(4*time.Minute + connIdleTimeout)
+	evictConnections(stat, later.UnixMicro())
+	if len(stat.conns) != 0 || len(stat.idleSessions) != 1 {
+		t.Fatalf("got %d conns %d idle sessions", len(stat.conns), len(stat.idleSessions))
+	}
+	w.feed(
+		conn.packet(t, true, command("GET", "user:1"), later),
+		conn.packet(t, false, "$1\r\nv\r\n", later.Add(100*time.Microsecond)),
+	)
+	if g := stat.tmpDbStats["5"]; g.Calls != 2 || len(stat.idleSessions) != 0 {
+		t.Fatalf("got db stats %+v after eviction", g)
+	}
+	if stat.ActiveProcessed != 1 {
+		t.Fatalf("got %d connections processed, want 1", stat.ActiveProcessed)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	multi := "*1\r\n$5\r\nMULTI\r\n"
+	exec := "*1\r\n$4\r\nEXEC\r\n"
+	set := "*3\r\n$3\r\nSET\r\n$6\r\nuser:1\r\n$1\r\nv\r\n"
+	get := "*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"
+	w.feed(
+		conn.packet(t, true, multi+set+get+exec, start),
+		conn.packet(t, false, "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n$1\r\nv\r\n", start.Add(300*time.Microsecond)),
+		// WATCH 的 key 被修改，EXEC 返回 nil
+		conn.packet(t, true, "*2\r\n$5\r\nWATCH\r\n$6\r\nuser:1\r\n"+multi+set+exec, start.Add(time.Millisecond)),
+		conn.packet(t, false, "+OK\r\n+OK\r\n+QUEUED\r\n*-1\r\n", start.Add(1100*time.Microsecond)),
+		conn.packet(t, true, multi+"*1\r\n$7\r\nDISCARD\r\n", start.Add(2*time.Millisecond)),
+		conn.packet(t, false, "+OK\r\n+OK\r\n", start.Add(2100*time.Microsecond)),
+	)
+	analysisTransaction(stat, 10)
+
+	tx := stat.Transactions
+	if tx.Transactions != 1 || tx.Commands != 2 || tx.AvgCommands != 2 || tx.WatchFails != 1 || tx.Discards != 1 || tx.ExecErrors != 0 {
+		t.Fatalf("got transactions %+v", tx)
+	}
+	if tx.ExecTime != 400 || tx.MaxExecTime != 300 {
+		t.Fatalf("got exec time %d max %d", tx.ExecTime, tx.MaxExecTime)
+	}
+	if len(stat.PipelineDepth) != 1 || stat.PipelineDepth[0].Key != "10.0.0.2" || stat.PipelineDepth[0].Value != 4 {
+		t.Fatalf("got pipeline depth %+v", stat.PipelineDepth)
+	}
+	// 事务中的 GET 响应为 QUEUED，不计入未命中率
+	if len(stat.tmpMissPrefixes) != 0 {
+		t.Fatalf("got miss prefixes %d", len(stat.tmpMissPrefixes))
+	}
+}
+
+func TestScripts(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	body := "return redis.call('get', KEYS[1])"
+	sha := "4e6d8fc8bb01276962cce5371fa795a7763657ae"
+	w.feed(
+		conn.packet(t, true, command("EVAL", body, "2", "user:1", "user:2", "arg"), start),
+		conn.packet(t, false, "$1\r\nv\r\n", start.Add(100*time.Microsecond)),
+		conn.packet(t, true, command("EVALSHA", sha, "1", "user:1"), start.Add(time.Millisecond)),
+		conn.packet(t, false, "$1\r\nv\r\n", start.Add(1300*time.Microsecond)),
+		conn.packet(t, true, command("EVALSHA", "ffffffffffffffffffffffffffffffffffffffff", "0"), start.Add(2*time.Millisecond)),
+		conn.packet(t, false, "-NOSCRIPT No matching script. Please use EVAL.\r\n", start.Add(2100*time.Microsecond)),
+		conn.packet(t, true, command("FCALL", "myfunc", "1", "order:1"), start.Add(3*time.Millisecond)),
+		conn.packet(t, false, ":1\r\n", start.Add(3100*time.Microsecond)),
+	)
+	analysisScript(stat, 10)
+
+	if len(stat.TopScripts) != 3 {
+		t.Fatalf("got %d scripts, want 3", len(stat.TopScripts))
+	}
+	if s := stat.TopScripts[0]; s.Key != sha || s.Calls != 2 || s.Body != body || s.Latency.Count != 2 || s.Latency.Max != 300 {
+		t.Fatalf("got script %+v", s)
+	}
+	for _, s := range stat.TopScripts[1:] {
+		if s.Key == "function:myfunc" && s.Calls == 1 && s.Errors == 0 {
+			continue
+		}
+		if s.Key[0] == 'f' && s.NoScript == 1 && s.Errors == 1 {
+			continue
+		}
+		t.Fatalf("got script %+v", s)
+	}
+	// numkeys 声明的 key 都计入 key 统计，SHA1 不作为 key
+	want := map[string]int64{"EVAL user:1": 1, "EVAL user:2": 1, "EVALSHA user:1": 1, "FCALL order:1": 1}
+	if len(stat.tmpTopKeys) != len(want) {
+		t.Fatalf("got keys %v", stat.tmpTopKeys)
+	}
+	for key, num := range want {
+		if stat.tmpTopKeys[key] != num {
+			t.Fatalf("got keys %v", stat.tmpTopKeys)
+		}
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	pub := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	sub := newTestConn("10.0.0.3", "10.0.0.1", 40001, 6379)
+	// 中途抓到的订阅连接，只有推送消息
+	old := newTestConn("10.0.0.4", "10.0.0.1", 40002, 6379)
+	message := "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	w.feed(
+		sub.packet(t, true, command("SUBSCRIBE", "news", "sports")+command("PSUBSCRIBE", "n*"), start),
+		sub.packet(t, false, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:3\r\n", start),
+		pub.packet(t, true, command("PUBLISH", "news", "hello")+command("PUBLISH", "news", "hello"), start.Add(time.Second)),
+		pub.packet(t, false, ":2\r\n:2\r\n", start.Add(time.Second)),
+		sub.packet(t, false, message+"*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n", start.Add(time.Second)),
+		old.packet(t, false, message, start.Add(time.Second)),
+	)
+	stat.MonitorStartTime, stat.MonitorEndTime = start.UnixMicro(), start.Add(2*time.Second).UnixMicro()
+	analysisPubSub(stat, 10)
+
+	if stat.UnmatchedReplySum != 0 {
+		t.Fatalf("got %d unmatched replies", stat.UnmatchedReplySum)
+	}
+	if len(stat.PubSubChannels) != 2 {
+		t.Fatalf("got %d channels, want 2", len(stat.PubSubChannels))
+	}
+	news := stat.PubSubChannels[0]
+	if news.Key != "news" || news.Publishes != 2 || news.PublishRate != 1 || news.PublishBytes != 10 || news.Receivers != 4 {
+		t.Fatalf("got channel %+v", news)
+	}
+	if news.Subscribers != 2 || news.Messages != 3 || news.FanoutBytes != int64(len(message)*2+47) {
+		t.Fatalf("got channel %+v", news)
+	}
+	if s := stat.PubSubChannels[1]; s.Key != "sports" || s.Subscribers != 1 || s.Messages != 0 {
+		t.Fatalf("got channel %+v", s)
+	}
+	if len(stat.PubSubPatterns) != 1 || stat.PubSubPatterns[0].Subscribers != 1 || stat.PubSubPatterns[0].Messages != 1 {
+		t.Fatalf("got patterns %+v", stat.PubSubPatterns)
+	}
+}
+
+func TestPubSubAfterIdle(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	sub := newTestConn("10.0.0.3", "10.0.0.1", 40001, 6379)
+	message := "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	w.feed(
+		sub.packet(t, true, command("SUBSCRIBE", "news"), start),
+		sub.packet(t, false, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", start),
+	)
+	// 订阅连接长时间没有消息，重组流释放后重建，订阅状态保存在连接表中
+	w.feed(
+		sub.packet(t, false, message, start.Add(5*time.Minute)),
+	)
+	s := stat.tmpChannels["news"]
+	if s.Subscribers != 1 || s.Messages != 1 || stat.UnmatchedReplySum != 0 {
+		t.Fatalf("got channel %+v, %d unmatched replies", s, stat.UnmatchedReplySum)
+	}
+}
+
+func TestBlockingCommands(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	w.feed(
+		conn.packet(t, true, command("BLPOP", "queue", "5"), start),
+		conn.packet(t, false, "*-1\r\n", start.Add(5*time.Second)),
+		conn.packet(t, true, command("BLPOP", "queue", "5"), start.Add(5*time.Second)),
+		conn.packet(t, false, "*2\r\n$5\r\nqueue\r\n$3\r\njob\r\n", start.Add(6*time.Second)),
+		conn.packet(t, true, command("XREAD", "BLOCK", "1000", "STREAMS", "events", "$"), start.Add(6*time.Second)),
+		conn.packet(t, false, "*-1\r\n", start.Add(7*time.Second)),
+		conn.packet(t, true, command("GET", "key"), start.Add(7*time.Second)),
+		conn.packet(t, false, "$1\r\nv\r\n", start.Add(7*time.Second+time.Millisecond)),
+		conn.packet(t, true, command("MULTI")+command("BLPOP", "queue", "5")+command("EXEC"), start.Add(8*time.Second)),
+		conn.packet(t, false, "+OK\r\n+QUEUED\r\n*1\r\n*-1\r\n", start.Add(8*time.Second+time.Millisecond)),
+	)
+	analysisBlocking(stat, 10)
+
+	if len(stat.BlockingKeys) != 2 {
+		t.Fatalf("got %d blocking keys, want 2", len(stat.BlockingKeys))
+	}
+	queue := stat.BlockingKeys[0]
+	if queue.Key != "BLPOP queue" || queue.Calls != 2 || queue.Served != 1 || queue.Timeouts != 1 || queue.TimeoutRatio != 0.5 {
+		t.Fatalf("got blocking stats %+v", queue)
+	}
+	if queue.WaitTime != 6000000 || queue.AvgWait != 3000000 || queue.MaxWait != 5000000 {
+		t.Fatalf("got blocking wait %+v", queue)
+	}
+	if s := stat.BlockingKeys[1]; s.Key != "XREAD events" || s.Timeouts != 1 {
+		t.Fatalf("got blocking stats %+v", s)
+	}
+	// 等待时间不计入服务端耗时，事务中的 BLPOP 不阻塞
+	if len(stat.SlowestCalls) != 4 || stat.TotalAccessTime != 4000 {
+		t.Fatalf("got slowest calls %d, total access time %d", len(stat.SlowestCalls), stat.TotalAccessTime)
+	}
+}
+
+func TestWaitCommands(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	w.feed(
+		conn.packet(t, true, command("WAIT", "1", "100")+command("WAIT", "2", "100"), start),
+		conn.packet(t, false, ":2\r\n:1\r\n", start.Add(50*time.Millisecond)),
+		// WAITAOF 的本地和副本数量分别和 numlocal、numreplicas 比较
+		conn.packet(t, true, command("WAITAOF", "1", "1", "100")+command("WAITAOF", "1", "2", "100")+command("WAITAOF", "1", "0", "100"), start.Add(time.Second)),
+		conn.packet(t, false, "*2\r\n:1\r\n:1\r\n*2\r\n:1\r\n:1\r\n*2\r\n:0\r\n:5\r\n", start.Add(time.Second+50*time.Millisecond)),
+	)
+	if s := stat.tmpBlocking["WAIT "]; s.Calls != 2 || s.Served != 1 || s.Timeouts != 1 {
+		t.Fatalf("got WAIT stats %+v", s)
+	}
+	if s := stat.tmpBlocking["WAITAOF "]; s.Calls != 3 || s.Served != 1 || s.Timeouts != 2 {
+		t.Fatalf("got WAITAOF stats %+v", s)
+	}
+}
+func TestCommandKeys(t *testing.T) {
+	cases := []struct {
+		args     []string
+		key      string
+		more     []string
+		blocking bool
+	}{
+		{[]string{"SET", "k1", "value"}, "k1", nil, false},
+		{[]string{"DEL", "a", "b"}, "a", []string{"b"}, false},
+		{[]string{"MSET", "k1", "v1", "k2", "v2"}, "k1", []string{"k2"}, false},
+		{[]string{"BLPOP", "q1", "q2", "0"}, "q1", []string{"q2"}, true},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "arg"}, "a", []string{"b"}, false},
+		{[]string{"XREAD", "BLOCK", "0", "STREAMS", "s1", "s2", "0", "0"}, "s1", []string{"s2"}, true},
+		{[]string{"OBJECT", "ENCODING", "user:1"}, "user:1", nil, false},
+		{[]string{"CONFIG", "GET", "maxmemory"}, "", nil, false},
+		{[]string{"SELECT", "5"}, "", nil, false},
+		{[]string{"WAIT", "1", "100"}, "", nil, true},
+		{[]string{"JSON.GET", "doc"}, "doc", nil, false},
+	}
+	for _, tc := range cases {
+		c := newRedisCommand(tc.args, 100, 0)
+		if c.key != tc.key || len(c.moreKeys) != len(tc.more) || c.blocking != tc.blocking {
+			t.Fatalf("%v: got key %q more %v blocking %v", tc.args, c.key, c.moreKeys, c.blocking)
+		}
+		for i, key := range tc.more {
+			if c.moreKeys[i] != key {
+				t.Fatalf("%v: got more keys %v, want %v", tc.args, c.moreKeys, tc.more)
+			}
+		}
+	}
+}
+
+func TestCommandClasses(t *testing.T) {
+	defer func(n int64) { minClassCalls = n }(minClassCalls)
+	minClassCalls = 4
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	var requests, replies string
+	for i := 0; i < 9; i++ {
+		requests += command("GET", "user:1")
+		replies += "$1\r\nv\r\n"
+	}
+	requests += command("SET", "user:1", "v") + command("SET", "lock:1", "v", "EX", "10") +
+		command("EXPIRE", "lock:1", "10") + command("DEL", "lock:1") + command("GET", "lock:1") +
+		command("CONFIG", "GET", "maxmemory")
+	replies += "+OK\r\n+OK\r\n:1\r\n:1\r\n$-1\r\n*0\r\n"
+	w.feed(
+		conn.packet(t, true, requests, start),
+		conn.packet(t, false, replies, start.Add(time.Millisecond)),
+	)
+	analysisClass(stat, 10)
+
+	classes := map[string]int64{}
+	for _, kv := range stat.CommandClasses {
+		classes[kv.Key] = kv.Value
+	}
+	want := map[string]int64{"read": 10, "write": 4, "delete": 1, "expire": 2, "admin": 1}
+	for key, value := range want {
+		if classes[key] != value {
+			t.Fatalf("got %s %d, want %d", key, classes[key], value)
+		}
+	}
+	if len(stat.ReadMostlyPrefixes) != 1 || stat.ReadMostlyPrefixes[0].Key != "user" || stat.ReadMostlyPrefixes[0].ReadRatio != 0.9 {
+		t.Fatalf("got read mostly prefixes %+v", stat.ReadMostlyPrefixes)
+	}
+	if len(stat.WriteHeavyPrefixes) != 1 {
+		t.Fatalf("got write heavy prefixes %+v", stat.WriteHeavyPrefixes)
+	}
+	lock := stat.WriteHeavyPrefixes[0]
+	if lock.Key != "lock" || lock.Total != 4 || lock.Write != 3 || lock.Delete != 1 || lock.Expire != 2 || lock.Read != 1 {
+		t.Fatalf("got write heavy prefix %+v", lock)
+	}
+}
+
+func TestRiskyCommands(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	mget := []string{"MGET"}
+	for i := 0; i < riskyArgs; i++ {
+		mget = append(mget, "key:"+strconv.Itoa(i))
+	}
+	members := "*" + strconv.Itoa(riskyReplyElements) + "\r\n"
+	for i := 0; i < riskyReplyElements; i++ {
+		members += ":1\r\n"
+	}
+	w.feed(
+		conn.packet(t, true, command("KEYS", "user:*"), start),
+		conn.packet(t, false, "*0\r\n", start.Add(20*time.Millisecond)),
+		conn.packet(t, true, command(mget...), start.Add(time.Second)),
+		conn.packet(t, false, "*0\r\n", start.Add(time.Second+time.Millisecond)),
+		conn.packet(t, true, command("SCAN", "0", "COUNT", "100000"), start.Add(2*time.Second)),
+		conn.packet(t, false, "*2\r\n$1\r\n0\r\n*0\r\n", start.Add(2*time.Second+2*time.Millisecond)),
+		conn.packet(t, true, command("SMEMBERS", "small")+command("SMEMBERS", "big"), start.Add(3*time.Second)),
+		conn.packet(t, false, "*1\r\n:1\r\n"+members, start.Add(3*time.Second+3*time.Millisecond)),
+		conn.packet(t, true, command("DEL", "small")+command("DEL", "big"), start.Add(4*time.Second)),
+		conn.packet(t, false, ":1\r\n", start.Add(4*time.Second)),
+		conn.packet(t, false, ":1\r\n", start.Add(4*time.Second+15*time.Millisecond)),
+	)
+	analysisRisky(stat, 10)
+
+	if len(stat.RiskyCommands) != 5 {
+		t.Fatalf("got risky commands %+v", stat.RiskyCommands)
+	}
+	if len(stat.RiskyCalls) != 5 {
+		t.Fatalf("got %d risky calls, want 5", len(stat.RiskyCalls))
+	}
+	keys := stat.RiskyCalls[0]
+	if keys.Reason != riskKeys || keys.Command != "KEYS user:*" || keys.Client != "10.0.0.2:40000" || keys.Latency != 20000 || keys.Time != start.UnixMicro() {
+		t.Fatalf("got risky call %+v", keys)
+	}
+	if d := stat.RiskyCalls[1]; d.Reason != riskSlowDelete || d.Command != "DEL big" {
+		t.Fatalf("got risky call %+v", d)
+	}
+	if s := stat.RiskyCalls[2]; s.Reason != riskFullRead || s.Command != "SMEMBERS big" || s.ReplyCount != riskyReplyElements || s.ReplyBytes != int64(len(members)) {
+		t.Fatalf("got risky call %+v", s)
+	}
+	if s := stat.RiskyCalls[3]; s.Reason != riskScanCount {
+		t.Fatalf("got risky call %+v", s)
+	}
+	if m := stat.RiskyCalls[4]; m.Reason != riskManyArgs || m.Args != riskyArgs || m.Command != "MGET key:0 key:1 key:2" {
+		t.Fatalf("got risky call %+v", m)
+	}
+}
+
+func TestRiskyKeepSlowest(t *testing.T) {
+	defer func(n int) { maxRiskyCalls = n }(maxRiskyCalls)
+	maxRiskyCalls = 2
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	keys := "*2\r\n$4\r\nKEYS\r\n$1\r\n*\r\n"
+	// 耗时在记录之后才知道，满了之后替换耗时最短的
+	for i, ms := range []int{5, 1, 20, 3} {
+		at := start.Add(time.Duration(i) * time.Second)
+		w.feed(
+			conn.packet(t, true, keys, at),
+			conn.packet(t, false, "*0\r\n", at.Add(time.Duration(ms)*time.Millisecond)),
+		)
+	}
+	analysisRisky(stat, 10)
+
+	if len(stat.RiskyCommands) != 1 || stat.RiskyCommands[0].Value != 4 {
+		t.Fatalf("got risky commands %+v", stat.RiskyCommands)
+	}
+	if len(stat.RiskyCalls) != 2 || stat.RiskyCalls[0].Latency != 20000 || stat.RiskyCalls[1].Latency != 5000 {
+		t.Fatalf("got risky calls %+v %+v", stat.RiskyCalls[0], stat.RiskyCalls[1])
+	}
+}
+
+func TestNetworkHealth(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	get := command("GET", "user:1")
+	lossy := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	reset := newTestConn("10.0.0.3", "10.0.0.1", 40001, 6379)
+	packets := []*NetPacket{
+		lossy.control(t, true, &layers.TCP{SYN: true}, start),
+		lossy.control(t, false, &layers.TCP{SYN: true, ACK: true}, start.Add(time.Millisecond)),
+		lossy.control(t, true, &layers.TCP{ACK: true}, start.Add(3*time.Millisecond)),
+		lossy.packet(t, true, get, start.Add(10*time.Millisecond)),
+	}
+	// 相同序号的请求重传一次
+	lossy.clientSeq -= uint32(len(get))
+	packets = append(packets, lossy.packet(t, true, get, start.Add(210*time.Millisecond)))
+	// 响应跳过了 10 个字节，乱序到达
+	lossy.serverSeq += 10
+	packets = append(packets, lossy.packet(t, false, "$1\r\nv\r\n", start.Add(211*time.Millisecond)))
+	// 客户端连续通告零窗口只算一次，窗口打开后再次变为零再算一次
+	window := func(size uint16, ms int) *NetPacket {
+		return buildPacket(t, lossy.client, lossy.server, &layers.TCP{
+			SrcPort: layers.TCPPort(lossy.clientPort),
+			DstPort: layers.TCPPort(lossy.serverPort),
+			Seq:     lossy.clientSeq,
+			Ack:     lossy.serverSeq,
+			ACK:     true,
+			Window:  size,
+		}, nil, start.Add(time.Duration(ms)*time.Millisecond))
+	}
+	packets = append(packets, window(0, 212), window(0, 213), window(0, 214), window(1024, 215), window(0, 216))
+	packets = append(packets,
+		reset.packet(t, true, get, start.Add(time.Second)),
+		reset.control(t, false, &layers.TCP{RST: true}, start.Add(time.Second)),
+	)
+	w.feed(packets...)
+	analysisNetwork(stat, 10)
+
+	n := stat.Network
+	if n.Segments != 4 || n.Retransmissions != 1 || n.OutOfOrder != 1 || n.RetransmitRatio != 0.25 {
+		t.Fatalf("got network %+v", n)
+	}
+	if n.ClientZeroWindows != 2 || n.ServerZeroWindows != 0 || n.ServerResets != 1 || n.ClientResets != 0 {
+		t.Fatalf("got network %+v", n)
+	}
+	if n.Handshakes != 1 || n.AvgServerRTT != 1000 || n.MaxServerRTT != 1000 || n.AvgClientRTT != 2000 || n.MaxClientRTT != 2000 {
+		t.Fatalf("got handshake %+v", n)
+	}
+	if len(stat.ClientNetwork) != 2 || stat.ClientNetwork[0].Key != "10.0.0.2" || stat.ClientNetwork[0].Retransmissions != 1 {
+		t.Fatalf("got client network %+v", stat.ClientNetwork)
+	}
+	if c := stat.ClientNetwork[1]; c.Key != "10.0.0.3" || c.ServerResets != 1 || c.Handshakes != 0 {
+		t.Fatalf("got client network %+v", c)
+	}
+	if len(stat.ServerNetwork) != 1 || stat.ServerNetwork[0].Key != "10.0.0.1:6379" || stat.ServerNetwork[0].problems() != 5 {
+		t.Fatalf("got server network %+v", stat.ServerNetwork)
+	}
+}
+
+func TestNetworkReorder(t *testing.T) {
+	w := newTestWorker(t, "10.0.0.1:6379")
+	stat := w.stats[0]
+	start := time.Unix(1700000000, 0)
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	first := conn.packet(t, true, command("GET", "user:1"), start)
+	second := conn.packet(t, true, command("GET", "user:2"), start.Add(time.Millisecond))
+	third := conn.packet(t, true, command("GET", "user:3"), start.Add(time.Millisecond))
+	// 第三个段先于第二个段到达，第二个段填补空洞，不是重传
+	w.feed(first, third, second)
+	if n := stat.Network; n.OutOfOrder != 1 || n.Retransmissions != 0 {
+		t.Fatalf("got network %+v", n)
+	}
+	// 已经收到过的段再次到达才是重传
+	conn.clientSeq -= uint32(len(command("GET", "user:3")))
+	w.feed(conn.packet(t, true, command("GET", "user:3"), start.Add(200*time.Millisecond)))
+	if n := stat.Network; n.OutOfOrder != 1 || n.Retransmissions != 1 || n.Segments != 4 {
+		t.Fatalf("got network %+v", n)
+	}
+}
+
+func TestPacketQueue(t *testing.T) {
+	conn := newTestConn("10.0.0.2", "10.0.0.1", 40000, 6379)
+	start := time.Unix(1700000000, 0)
+	packet := conn.packet(t, true, command("GET", "user:1"), start).PacketContent
+	tcp := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	p := newTCPPacket(packet.NetworkLayer(), tcp, packet.Metadata().CaptureInfo)
+	if p.tcp.Contents != nil || string(p.tcp.Payload) != command("GET", "user:1") || p.receiveTime != start.UnixMicro() {
+		t.Fatalf("got tcp packet %+v", p)
+	}
+	if p.flow.Src().String() != "10.0.0.2" || p.tcp.Seq != tcp.Seq || !p.tcp.PSH {
+		t.Fatalf("got tcp packet flow %v seq %d", p.flow, p.tcp.Seq)
+	}
+	ch := make(chan *tcpPacket, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if !enqueue(ctx, ch, p, false) {
+		t.Fatal("enqueue to empty queue failed")
+	}
+	if enqueue(ctx, ch, p, false) {
+		t.Fatal("enqueue to full queue succeeded")
+	}
+	<-ch
+	if !enqueue(ctx, ch, p, true) || len(ch) != 1 {
+		t.Fatal("waiting enqueue failed")
+	}
+	// 离线文件等待时取消，不会一直阻塞
+	cancel()
+	if enqueue(ctx, ch, p, true) {
+		t.Fatal("waiting enqueue succeeded after cancel")
+	}
+}
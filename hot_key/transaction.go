@@ -0,0 +1,111 @@
+package hotkeys
+
+// TransactionStats MULTI/EXEC 事务统计，按匹配到的 EXEC 响应计算
+type TransactionStats struct {
+	Transactions int64   `json:"transactions"`  // EXEC 成功执行的事务数
+	Discards     int64   `json:"discards"`      // DISCARD 次数
+	WatchFails   int64   `json:"watch_fails"`   // WATCH 的 key 被修改，EXEC 返回 nil 的次数
+	ExecErrors   int64   `json:"exec_errors"`   // EXEC 返回错误的次数，例如 EXECABORT
+	Commands     int64   `json:"commands"`      // 成功执行的事务中的命令总数
+	AvgCommands  float64 `json:"avg_commands"`  // 平均每个事务的命令数
+	MaxCommands  int64   `json:"max_commands"`  // 单个事务最多的命令数
+	ExecTime     int64   `json:"exec_time"`     // EXEC 总耗时，微秒
+	MaxExecTime  int64   `json:"max_exec_time"` // EXEC 最大耗时，微秒
+}
+
+// trackTransaction 按请求顺序跟踪连接上的 MULTI/EXEC/DISCARD
+func (s *redisStream) trackTransaction(c *redisCommand) {
+	switch c.cmd {
+	case "MULTI":
+		s.multi = true
+		s.queued = 0
+	case "EXEC":
+		if s.multi {
+			c.queued = s.queued
+		}
+		s.multi = false
+	case "DISCARD":
+		if s.multi {
+			s.stat.Transactions.Discards++
+		}
+		s.multi = false
+	default:
+		if s.multi {
+			// 事务中的命令响应为 QUEUED，阻塞命令在事务中不会阻塞
+			c.inMulti = true
+			c.blocking = false
+			s.queued++
+		}
+	}
+}
+
+// execReplyInfo 统计 EXEC 的响应
+func execReplyInfo(c *redisCommand, reply *respValue, stat *OverallStats) {
+	if c.cmd != "EXEC" {
+		return
+	}
+	t := &stat.Transactions
+	switch {
+	case reply.kind == '-' || reply.kind == '!':
+		t.ExecErrors++
+	case reply.isNil:
+		t.WatchFails++
+	default:
+		t.Transactions++
+		n := c.queued
+		if n == 0 {
+			// 中途抓到的事务，以响应的元素个数为准
+			n = int64(reply.count)
+		}
+		t.Commands += n
+		if n > t.MaxCommands {
+			t.MaxCommands = n
+		}
+	}
+}
+
+// execLatencyInfo 统计 EXEC 的耗时
+func execLatencyInfo(c *redisCommand, execTime int64, stat *OverallStats) {
+	if c.cmd != "EXEC" {
+		return
+	}
+	stat.Transactions.ExecTime += execTime
+	if execTime > stat.Transactions.MaxExecTime {
+		stat.Transactions.MaxExecTime = execTime
+	}
+}
+
+// pipelineInfo 按客户端记录等待响应的命令数最大值
+func pipelineInfo(clientIp string, depth int, stat *OverallStats) {
+	if int64(depth) > stat.tmpPipelineDepth[clientIp] {
+		stat.tmpPipelineDepth[clientIp] = int64(depth)
+	}
+}
+
+func aggregationTransaction(stat *OverallStats, newStat *OverallStats) {
+	t, n := &stat.Transactions, &newStat.Transactions
+	t.Transactions += n.Transactions
+	t.Discards += n.Discards
+	t.WatchFails += n.WatchFails
+	t.ExecErrors += n.ExecErrors
+	t.Commands += n.Commands
+	t.ExecTime += n.ExecTime
+	if n.MaxCommands > t.MaxCommands {
+		t.MaxCommands = n.MaxCommands
+	}
+	if n.MaxExecTime > t.MaxExecTime {
+		t.MaxExecTime = n.MaxExecTime
+	}
+	for key, value := range newStat.tmpPipelineDepth {
+		if value > stat.tmpPipelineDepth[key] {
+			stat.tmpPipelineDepth[key] = value
+		}
+	}
+}
+
+func analysisTransaction(stat *OverallStats, topNum int) {
+	if stat.Transactions.Transactions > 0 {
+		stat.Transactions.AvgCommands = Decimal(float64(stat.Transactions.Commands) / float64(stat.Transactions.Transactions))
+	}
+	stat.PipelineDepth = topKv(stat.tmpPipelineDepth, topNum)
+}
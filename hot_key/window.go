@@ -0,0 +1,304 @@
+package hotkeys
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sort"
+	"sync"
+)
+
+// windowDone 线程结束后的窗口进度，之前的窗口都已交出
+const windowDone = math.MaxInt64
+
+// WindowStats 一个时间窗口内的访问统计，窗口按包时间划分
+// 全部线程都进入两个窗口之后时窗口结束，合并全部线程的数据后取 top，输出到日志
+type WindowStats struct {
+	StartTime      int64   `json:"start_time"`   // 窗口开始时间，时间戳，微秒
+	EndTime        int64   `json:"end_time"`     // 窗口结束时间，时间戳，微秒
+	TotalAccessSum int64   `json:"total_sum"`    // 窗口内访问次数，包括窗口结束后迟到的命令
+	CommandsSec    float64 `json:"commands_sec"` // 窗口内平均每秒访问次数
+	TopKeys        []*KV   `json:"top_keys"`     // 窗口内使用最多的key
+	TopCommands    []*KV   `json:"top_commands"` // 窗口内使用最多的命令
+	TopPrefixes    []*KV   `json:"top_prefixes"` // 窗口内访问次数最多的前缀
+	keys           map[string]int64
+	commands       map[string]int64
+	prefixes       map[string]int64
+}
+
+func newWindowStats(id, size int64) *WindowStats {
+	return &WindowStats{
+		StartTime:   id * size,
+		EndTime:     (id + 1) * size,
+		TopKeys:     []*KV{},
+		TopCommands: []*KV{},
+		TopPrefixes: []*KV{},
+		keys:        map[string]int64{},
+		commands:    map[string]int64{},
+		prefixes:    map[string]int64{},
+	}
+}
+
+// merge 累加另一个线程同一个窗口的数据
+func (w *WindowStats) merge(o *WindowStats) {
+	w.TotalAccessSum += o.TotalAccessSum
+	for key, num := range o.keys {
+		w.keys[key] += num
+	}
+	for key, num := range o.commands {
+		w.commands[key] += num
+	}
+	for key, num := range o.prefixes {
+		w.prefixes[key] += num
+	}
+}
+
+// windowCounter 一个分析线程按窗口统计，乱序包只会落在最近的两个窗口，更早的窗口交给 windowMerger
+type windowCounter struct {
+	size    int64 // 窗口大小，微秒
+	limit   int   // 近似统计时每个窗口保留的 key 和前缀数量，精确统计时为 0
+	last    int64 // 最新的窗口编号
+	windows map[int64]*WindowStats
+	merger  *windowMerger
+	member  int // 在 merger 中的编号，没有数据时为 -1
+}
+
+// windowInfo 按命令的接收时间统计到对应窗口
+func windowInfo(c *redisCommand, prefixes []string, stat *OverallStats) {
+	wc := stat.windows
+	if wc == nil {
+		return
+	}
+	id := c.receiveTime / wc.size
+	wc.advance(c.receiveTime)
+	w, ok := wc.windows[id]
+	if !ok {
+		w = newWindowStats(id, wc.size)
+		wc.windows[id] = w
+	}
+	w.TotalAccessSum++
+	if c.key != "" {
+		w.keys[c.redisCmd]++
+	}
+	w.commands[c.cmd]++
+	for _, prefix := range prefixes {
+		if len(prefix) == 0 {
+			continue
+		}
+		w.prefixes[prefix]++
+	}
+	w.trim(wc.limit)
+}
+
+// trim 近似统计时 key 和前缀超过两倍 limit 后裁剪为访问最多的 limit 个
+func (w *WindowStats) trim(limit int) {
+	if limit <= 0 {
+		return
+	}
+	if len(w.keys) >= limit*2 {
+		w.keys = trimCounter(w.keys, limit)
+	}
+	if len(w.prefixes) >= limit*2 {
+		w.prefixes = trimCounter(w.prefixes, limit)
+	}
+}
+
+// advanceWindows 按包时间或分发协程的时钟推进窗口，空闲的线程也能让窗口结束
+func advanceWindows(stat *OverallStats, now int64) {
+	if stat.windows != nil {
+		stat.windows.advance(now)
+	}
+}
+
+// advance 进入新窗口时交出两个窗口之前的数据
+// 第一次推进时加入合并，分发协程开始时给每个线程发送时钟，全部线程在有数据之前加入
+func (wc *windowCounter) advance(now int64) {
+	id := now / wc.size
+	if wc.member < 0 {
+		wc.last = id
+		wc.member = wc.merger.join(id)
+		return
+	}
+	if id <= wc.last {
+		return
+	}
+	wc.last = id
+	wc.handOff(id-1, id)
+}
+
+// flush 线程结束时交出全部窗口
+func (wc *windowCounter) flush() {
+	if wc.member < 0 {
+		return
+	}
+	wc.handOff(windowDone, windowDone)
+}
+
+// handOff 交出编号小于 before 的窗口，progress 为线程最新的窗口编号
+func (wc *windowCounter) handOff(before, progress int64) {
+	var closed []*WindowStats
+	for id, w := range wc.windows {
+		if id < before {
+			closed = append(closed, w)
+			delete(wc.windows, id)
+		}
+	}
+	wc.merger.add(wc.member, closed, progress)
+}
+
+// windowMerger 合并多个线程的窗口，线程之间共享
+// 全部成员的进度都超过窗口两个以上时窗口结束，在合并之后才取 top
+// 多个实例时，实例的 windowMerger 作为成员把结束的窗口交给整体的 windowMerger
+type windowMerger struct {
+	mu       sync.Mutex
+	name     string // 日志中的实例名，整体为空
+	size     int64
+	topNum   int
+	limit    int     // 近似统计时每个窗口保留的 key 和前缀数量，精确统计时为 0
+	progress []int64 // 每个成员最新的窗口编号
+	pending  map[int64]*WindowStats
+	closed   map[int64]*WindowStats
+	parent   *windowMerger
+	member   int // 在 parent 中的编号，还没有加入时为 -1
+}
+
+func newWindowMerger(size int64, topNum int) *windowMerger {
+	return &windowMerger{
+		size:    size,
+		topNum:  topNum,
+		pending: map[int64]*WindowStats{},
+		closed:  map[int64]*WindowStats{},
+		member:  -1,
+	}
+}
+
+// counter 创建一个线程的 windowCounter，结束的窗口交给 m 合并
+func (m *windowMerger) counter() *windowCounter {
+	return &windowCounter{
+		size:    m.size,
+		limit:   m.limit,
+		windows: map[int64]*WindowStats{},
+		merger:  m,
+		member:  -1,
+	}
+}
+
+// join 加入一个成员，返回成员编号，progress 之前的窗口不等待该成员
+// 第一个成员加入时同时加入 parent，各实例的线程都在有数据之前加入
+func (m *windowMerger) join(progress int64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.parent != nil && m.member < 0 {
+		m.member = m.parent.join(progress)
+	}
+	m.progress = append(m.progress, progress)
+	return len(m.progress) - 1
+}
+
+// add 合并成员交出的窗口并更新它的进度，已经结束的窗口只累加访问次数
+func (m *windowMerger) add(member int, windows []*WindowStats, progress int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range windows {
+		id := w.StartTime / m.size
+		if c, ok := m.closed[id]; ok {
+			log.Debugf("窗口 %d 结束后收到 %d 次访问", w.StartTime, w.TotalAccessSum)
+			c.TotalAccessSum += w.TotalAccessSum
+			continue
+		}
+		p, ok := m.pending[id]
+		if !ok {
+			p = newWindowStats(id, m.size)
+			m.pending[id] = p
+		}
+		p.merge(w)
+		p.trim(m.limit)
+	}
+	if progress > m.progress[member] {
+		m.progress[member] = progress
+	}
+	m.closeWindows()
+}
+
+// finish 全部线程结束后结束剩余的窗口
+func (m *windowMerger) finish() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.progress {
+		m.progress[i] = windowDone
+	}
+	m.closeWindows()
+}
+
+// closeWindows 结束全部成员都已交出的窗口，交给 parent 之后取 top 并输出到日志
+func (m *windowMerger) closeWindows() {
+	if len(m.progress) == 0 {
+		return
+	}
+	min := int64(windowDone)
+	for _, p := range m.progress {
+		if p < min {
+			min = p
+		}
+	}
+	var closed []*WindowStats
+	for id, w := range m.pending {
+		if min == windowDone || id < min-1 {
+			closed = append(closed, w)
+			delete(m.pending, id)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].StartTime < closed[j].StartTime })
+	if m.parent != nil && m.member >= 0 {
+		m.parent.add(m.member, closed, min)
+	}
+	for _, w := range closed {
+		w.CommandsSec = Decimal(float64(w.TotalAccessSum) / (float64(m.size) / 1000 / 1000))
+		w.TopKeys = topKv(w.keys, m.topNum)
+		w.TopCommands = topKv(w.commands, m.topNum)
+		w.TopPrefixes = topKv(w.prefixes, m.topNum)
+		w.keys, w.commands, w.prefixes = nil, nil, nil
+		m.closed[w.StartTime/m.size] = w
+		if data, err := json.Marshal(w); err == nil {
+			log.Infof("窗口快照%s %s", m.name, data)
+		}
+	}
+}
+
+// trimCounter 只保留值最大的 keep 个
+func trimCounter(m map[string]int64, keep int) map[string]int64 {
+	if len(m) <= keep {
+		return m
+	}
+	res := make(map[string]int64, keep)
+	for _, kv := range topKv(m, keep) {
+		res[kv.Key] = kv.Value
+	}
+	return res
+}
+
+// aggregationWindow 交出线程剩余的窗口，多个实例时实例的窗口交给整体
+func aggregationWindow(stat *OverallStats, newStat *OverallStats) {
+	if newStat.windows != nil {
+		newStat.windows.flush()
+	}
+	if newStat.windowMerger != nil && newStat.windowMerger.parent != nil {
+		newStat.windowMerger.finish()
+	}
+}
+
+func analysisWindow(stat *OverallStats, topNum int) {
+	if stat.windowMerger == nil {
+		return
+	}
+	if stat.windows != nil {
+		stat.windows.flush()
+	}
+	stat.windowMerger.finish()
+	stat.windowMerger.mu.Lock()
+	defer stat.windowMerger.mu.Unlock()
+	for _, w := range stat.windowMerger.closed {
+		stat.Windows = append(stat.Windows, w)
+	}
+	sort.Slice(stat.Windows, func(i, j int) bool { return stat.Windows[i].StartTime < stat.Windows[j].StartTime })
+}
@@ -6,42 +6,78 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"os"
+	"os/signal"
 	. "redis_performance_analysis/big_key/dump"
 	. "redis_performance_analysis/hot_key"
+	"syscall"
+	"time"
 )
 
 var (
-	BigKey               bool   // enable big key analysis
-	HotKey               bool   // enable hot key analysis
-	PathAddr             string // path to addr
-	KeyTop               uint   // key top number
-	MonitorTime          uint   // hotkey monitor time
-	MonitorPort          uint   // hotkey monitor port
-	MonitorIp            string // hotkey monitor ip
-	MonitorDevice        string // hotkey monitor device
-	MaxKeyLength         uint   // show hot key max key length
-	AnalysisThreadNumber uint32 // analysis thread number
-	WriteFile            bool   // hot key write file
-	Version              bool   // show version info
-	Help                 bool   // show help info
-	OfflineMode          bool   // offline mode
+	BigKey               bool          // enable big key analysis
+	HotKey               bool          // enable hot key analysis
+	PathAddr             string        // path to addr
+	KeyTop               uint          // key top number
+	MonitorTime          uint          // hotkey monitor time
+	MonitorPort          uint          // hotkey monitor port
+	MonitorIp            string        // hotkey monitor ip, ipv4/ipv6 address or cidr list
+	MonitorDevice        string        // hotkey monitor device
+	MaxKeyLength         uint          // show hot key max key length
+	AnalysisThreadNumber uint32        // analysis thread number
+	WriteFile            bool          // hot key write file
+	Version              bool          // show version info
+	Help                 bool          // show help info
+	OfflineMode          bool          // offline mode
+	BpfFilter            string        // hot key bpf filter expression
+	MonitorWindow        time.Duration // hot key window size, top snapshot per window
+	KeyMemory            uint          // hot key approximate counting memory limit, MB
+	MonitorEndpoints     []string      // hot key redis instances, ip:port list
+	CommandLog           bool          // analyze hot key command log written by write file
+	KeySeparators        string        // hot key prefix separators
+	StartTime            string        // command log start time
+	EndTime              string        // command log end time
+	MonitorFile          string        // redis MONITOR output file, - for stdin
+	ProxyListen          string        // hot key proxy listen address
+	ProxyTarget          string        // hot key proxy redis address
+	RedactValues         bool          // hot key write file keeps keys and hashes other arguments
+	RedactRules          []string      // hot key write file regex rules masked with ***
+	QueueSize            uint          // hot key packet queue size per analysis thread
 )
 
+// addFlags registers the command line flags on fs
+func addFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&BigKey, "big-key", "b", false, "enable big key analysis")
+	fs.BoolVarP(&HotKey, "hot-key", "h", false, "enable hot key analysis")
+	fs.StringVarP(&PathAddr, "path", "p", "", "path to addr")
+	fs.UintVarP(&KeyTop, "key-top", "k", 100, "key top number")
+	fs.UintVarP(&MonitorTime, "monitor-time", "m", 10, "hotkey monitor time")
+	fs.UintVarP(&MaxKeyLength, "max-key-length", "l", 100, "show hot key max key length")
+	fs.Uint32VarP(&AnalysisThreadNumber, "thread-number", "t", 5, "analysis thread number")
+	fs.BoolVarP(&WriteFile, "write-file", "w", false, "hot key write file")
+	fs.StringVarP(&MonitorDevice, "device", "d", "", "hotkey monitor device")
+	fs.StringVarP(&MonitorIp, "ip", "i", "", "hotkey monitor ip, ipv4/ipv6 address or cidr, separated by comma")
+	fs.UintVarP(&MonitorPort, "port", "s", 0, "hotkey monitor port")
+	fs.BoolVarP(&Version, "version", "v", false, "show version info")
+	fs.BoolVarP(&OfflineMode, "offline-mode", "o", false, "offline mode")
+	fs.StringVarP(&BpfFilter, "bpf", "f", "", "hot key bpf filter expression, default built from port and ip")
+	fs.DurationVar(&MonitorWindow, "window", 0, "hot key window size, e.g. 10s, logs a top snapshot by packet time when each window closes")
+	fs.UintVar(&KeyMemory, "key-memory", 0, "hot key approximate counting memory limit in MB, 0 counts every key exactly")
+	fs.StringSliceVarP(&MonitorEndpoints, "endpoints", "e", nil, "hot key redis instances ip:port, separated by comma, e.g. 10.0.0.1:6379,[::1]:6380,:6381; overrides ip and port")
+	fs.BoolVarP(&CommandLog, "command-log", "c", false, "analyze hot key command log written by write file, path is a file or directory")
+	fs.StringVar(&KeySeparators, "separators", "", "hot key prefix separators, default \":;,_- \"")
+	fs.StringVar(&StartTime, "start-time", "", "command log or monitor start time, e.g. 2024-01-02 15:04:05 or RFC3339")
+	fs.StringVar(&EndTime, "end-time", "", "command log or monitor end time, e.g. 2024-01-02 15:04:05 or RFC3339")
+	fs.StringVar(&MonitorFile, "monitor-file", "", "analyze redis MONITOR output file, - reads stdin until EOF, e.g. timeout 60 redis-cli monitor | ...")
+	fs.StringVar(&ProxyListen, "proxy-listen", "", "hot key proxy mode listen address, e.g. :16379, clients connect here instead of redis")
+	fs.StringVar(&ProxyTarget, "proxy-target", "", "hot key proxy mode redis address ip:port")
+	fs.BoolVar(&RedactValues, "redact", false, "hot key write file keeps keys and replaces other arguments with sha256 hashes, passwords are always masked")
+	fs.StringArrayVar(&RedactRules, "redact-rule", nil, "hot key write file regex rule, matched content is replaced with ***, repeat the flag for more rules, e.g. [0-9]{11,13}")
+	fs.UintVar(&QueueSize, "queue-size", 100000, "hot key packet queue size per analysis thread, live capture drops packets when full and reports queue_drop_sum")
+	fs.BoolVar(&Help, "help", false, "show help info")
+}
+
 func Run() {
-	pflag.BoolVarP(&BigKey, "big-key", "b", false, "enable big key analysis")
-	pflag.BoolVarP(&HotKey, "hot-key", "h", false, "enable hot key analysis")
-	pflag.StringVarP(&PathAddr, "path", "p", "", "path to addr")
-	pflag.UintVarP(&KeyTop, "key-top", "k", 100, "key top number")
-	pflag.UintVarP(&MonitorTime, "monitor-time", "m", 10, "hotkey monitor time")
-	pflag.UintVarP(&MaxKeyLength, "max-key-length", "l", 100, "show hot key max key length")
-	pflag.Uint32VarP(&AnalysisThreadNumber, "thread-number", "t", 5, "analysis thread number")
-	pflag.BoolVarP(&WriteFile, "write-file", "w", false, "hot key write file")
-	pflag.StringVarP(&MonitorDevice, "device", "d", "", "hotkey monitor device")
-	pflag.StringVarP(&MonitorIp, "ip", "i", "", "hotkey monitor ip")
-	pflag.UintVarP(&MonitorPort, "port", "s", 0, "hotkey monitor port")
-	pflag.BoolVarP(&Version, "version", "v", false, "show version info")
-	pflag.BoolVarP(&OfflineMode, "offline-mode", "o", false, "offline mode")
-	pflag.BoolVar(&Help, "help", false, "show help info")
+	addFlags(pflag.CommandLine)
 	pflag.Parse()
 
 	if Version {
@@ -77,8 +113,20 @@ func LoadBigKey() {
 }
 
 func LoadHotKey() {
-	if MonitorPort == 0 {
-		log.Errorf("hot key analysis requires port")
+	if CommandLog {
+		LoadCommandLog()
+		return
+	}
+	if MonitorFile != "" {
+		LoadMonitor()
+		return
+	}
+	if ProxyListen != "" {
+		LoadProxy()
+		return
+	}
+	if MonitorPort == 0 && len(MonitorEndpoints) == 0 {
+		log.Errorf("hot key analysis requires port or endpoints")
 		return
 	}
 	var data map[string]interface{}
@@ -92,8 +140,7 @@ func LoadHotKey() {
 				log.Errorf("open pcap file %s fail, err: %v", a, err)
 				continue
 			}
-			data, err = ShowHotKeys(context.Background(), "", int(MonitorTime), int(MonitorPort), int(MaxKeyLength),
-				int(KeyTop), pcapFile, WriteFile, MonitorIp, AnalysisThreadNumber)
+			data, err = ShowHotKeys(context.Background(), hotKeyOptions(pcapFile))
 			if err != nil {
 				log.Errorf("show hot key file %s fail, err: %v", a, err)
 				continue
@@ -101,8 +148,7 @@ func LoadHotKey() {
 			fmt.Println(data)
 		}
 	} else {
-		data, err = ShowHotKeys(context.Background(), MonitorDevice, int(MonitorTime), int(MonitorPort), int(MaxKeyLength),
-			int(KeyTop), nil, WriteFile, MonitorIp, AnalysisThreadNumber)
+		data, err = ShowHotKeys(context.Background(), hotKeyOptions(nil))
 		if err != nil {
 			log.Errorf("show hot key fail, err: %v", err)
 			return
@@ -110,3 +156,95 @@ func LoadHotKey() {
 		fmt.Println(data)
 	}
 }
+
+func LoadCommandLog() {
+	if PathAddr == "" {
+		log.Errorf("command log analysis requires path to addr")
+		return
+	}
+	opts := hotKeyOptions(nil)
+	opts.CommandLogs = []string{PathAddr}
+	if !timeRangeOptions(opts) {
+		return
+	}
+	data, err := ShowCommandLog(context.Background(), opts)
+	if err != nil {
+		log.Errorf("show command log %s fail, err: %v", PathAddr, err)
+		return
+	}
+	fmt.Println(data)
+}
+
+func LoadMonitor() {
+	opts := hotKeyOptions(nil)
+	opts.MonitorFile = os.Stdin
+	if MonitorFile != "-" {
+		f, err := os.Open(MonitorFile)
+		if err != nil {
+			log.Errorf("open monitor file %s fail, err: %v", MonitorFile, err)
+			return
+		}
+		defer f.Close()
+		opts.MonitorFile = f
+	}
+	if !timeRangeOptions(opts) {
+		return
+	}
+	data, err := ShowMonitor(context.Background(), opts)
+	if err != nil {
+		log.Errorf("show monitor %s fail, err: %v", MonitorFile, err)
+		return
+	}
+	fmt.Println(data)
+}
+
+// LoadProxy stops after monitor time or on interrupt and prints the stats
+func LoadProxy() {
+	opts := hotKeyOptions(nil)
+	opts.ProxyListen = ProxyListen
+	opts.ProxyTarget = ProxyTarget
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	data, err := ShowProxy(ctx, opts)
+	if err != nil {
+		log.Errorf("show proxy %s fail, err: %v", ProxyListen, err)
+		return
+	}
+	fmt.Println(data)
+}
+
+// timeRangeOptions sets the command time range of file sources
+func timeRangeOptions(opts *HotKeyOptions) bool {
+	var err error
+	if opts.StartTime, err = parseTime(StartTime); err != nil {
+		log.Errorf("parse start time %s fail, err: %v", StartTime, err)
+		return false
+	}
+	if opts.EndTime, err = parseTime(EndTime); err != nil {
+		log.Errorf("parse end time %s fail, err: %v", EndTime, err)
+		return false
+	}
+	return true
+}
+
+func hotKeyOptions(pcapFile *os.File) *HotKeyOptions {
+	return &HotKeyOptions{
+		Device:      MonitorDevice,
+		MonitorTime: int(MonitorTime),
+		Port:        int(MonitorPort),
+		CmdLen:      int(MaxKeyLength),
+		Top:         int(KeyTop),
+		PcapFile:    pcapFile,
+		WriteFile:   WriteFile,
+		HostIp:      MonitorIp,
+		ThreadNum:   AnalysisThreadNumber,
+		Bpf:         BpfFilter,
+		Window:      MonitorWindow,
+		KeyMemory:   int64(KeyMemory) << 20,
+		Endpoints:   MonitorEndpoints,
+		Separators:  KeySeparators,
+		Redact:      RedactValues,
+		RedactRules: RedactRules,
+		QueueSize:   int(QueueSize),
+	}
+}
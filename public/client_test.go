@@ -0,0 +1,19 @@
+package public
+
+import (
+	"github.com/spf13/pflag"
+	"testing"
+)
+
+func TestRedactRuleFlag(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addFlags(fs)
+	// commas inside a rule do not split it, each occurrence is one rule
+	err := fs.Parse([]string{"--redact-rule", "[0-9]{11,13}", "--redact-rule", "(a|b),c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(RedactRules) != 2 || RedactRules[0] != "[0-9]{11,13}" || RedactRules[1] != "(a|b),c" {
+		t.Fatalf("got redact rules %q", RedactRules)
+	}
+}
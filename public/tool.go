@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 var (
@@ -49,6 +50,21 @@ func readFileName(inPath string, fileType string) []string {
 	return fileList
 }
 
+// parseTime parses local time "2006-01-02 15:04:05" or RFC3339 to a microsecond timestamp, empty is 0.
+func parseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.ParseInLocation(time.DateTime, value, time.Local)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMicro(), nil
+}
+
 // readNetDriveName reads the network drive name from the given IP address.
 func readNetDriveName(ip string) string {
 	return ""